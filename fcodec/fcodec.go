@@ -0,0 +1,135 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+// Package fcodec supports binary encoding of structs without
+// reflection. The Size, EncodeTo and DecodeFrom methods are generated
+// by fcodecgen, this package holds the helpers used by the generated
+// code and functions which encode directly into a ByteMsgQ.
+//
+// All integers are little-endian and fixed width. Strings and []byte
+// are a uint32 length followed by their bytes. Repeated groups, slices
+// of generated structs, are a uint32 count followed by each element.
+package fcodec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/fmstephe/flib/queues/spscq"
+)
+
+type Encoder interface {
+	// The number of bytes EncodeTo will write
+	Size() int64
+	// Encodes into b, which must be at least Size() bytes long. Returns
+	// the number of bytes written.
+	EncodeTo(b []byte) int64
+}
+
+type Decoder interface {
+	// Decodes from b. Returns the number of bytes read, and an error if
+	// b is too short.
+	DecodeFrom(b []byte) (int64, error)
+}
+
+// Encodes m directly into q. Returns false if q is full.
+func Write(q *spscq.ByteMsgQ, m Encoder) bool {
+	msg := q.AcquireWrite(m.Size())
+	if msg == nil {
+		return false
+	}
+	m.EncodeTo(msg)
+	q.ReleaseWrite()
+	return true
+}
+
+// Encodes m directly into q, releasing lazily. Returns false if q is
+// full.
+func WriteLazy(q *spscq.ByteMsgQ, m Encoder) bool {
+	msg := q.AcquireWrite(m.Size())
+	if msg == nil {
+		return false
+	}
+	m.EncodeTo(msg)
+	q.ReleaseWriteLazy()
+	return true
+}
+
+// Decodes the next message in q into m. Returns false if q is empty.
+// The message is released even if it could not be decoded.
+func Read(q *spscq.ByteMsgQ, m Decoder) (bool, error) {
+	msg := q.AcquireRead()
+	if msg == nil {
+		return false, nil
+	}
+	_, err := m.DecodeFrom(msg)
+	q.ReleaseRead()
+	return true, err
+}
+
+// Decodes the next message in q into m, releasing lazily. Returns false
+// if q is empty. The message is released even if it could not be
+// decoded.
+func ReadLazy(q *spscq.ByteMsgQ, m Decoder) (bool, error) {
+	msg := q.AcquireRead()
+	if msg == nil {
+		return false, nil
+	}
+	_, err := m.DecodeFrom(msg)
+	q.ReleaseReadLazy()
+	return true, err
+}
+
+// Returns an error if b does not have n bytes available from i.
+// Field names the value being decoded.
+func Check(b []byte, i, n int64, field string) error {
+	if int64(len(b))-i < n {
+		return errors.New(fmt.Sprintf("Decoding %s needs %d bytes, %d available", field, n, int64(len(b))-i))
+	}
+	return nil
+}
+
+func PutString(b []byte, s string) int64 {
+	binary.LittleEndian.PutUint32(b, uint32(len(s)))
+	return 4 + int64(copy(b[4:], s))
+}
+
+func GetString(b []byte, field string) (string, int64, error) {
+	if err := Check(b, 0, 4, field); err != nil {
+		return "", 0, err
+	}
+	n := int64(binary.LittleEndian.Uint32(b))
+	if err := Check(b, 4, n, field); err != nil {
+		return "", 0, err
+	}
+	return string(b[4 : 4+n]), 4 + n, nil
+}
+
+func PutBytes(b []byte, bs []byte) int64 {
+	binary.LittleEndian.PutUint32(b, uint32(len(bs)))
+	return 4 + int64(copy(b[4:], bs))
+}
+
+// Appends the decoded bytes to dst, which is usually the field's
+// previous value truncated to zero length. This avoids allocating when
+// dst has enough capacity.
+func GetBytes(b []byte, dst []byte, field string) ([]byte, int64, error) {
+	if err := Check(b, 0, 4, field); err != nil {
+		return dst, 0, err
+	}
+	n := int64(binary.LittleEndian.Uint32(b))
+	if err := Check(b, 4, n, field); err != nil {
+		return dst, 0, err
+	}
+	return append(dst, b[4:4+n]...), 4 + n, nil
+}
+
+// Returns a slice of length n, reusing s if it has enough capacity.
+func Resize[T any](s []T, n int) []T {
+	if cap(s) >= n {
+		return s[:n]
+	}
+	return make([]T, n)
+}
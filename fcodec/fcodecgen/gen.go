@@ -0,0 +1,299 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+// Structs whose doc comment contains this line are generated
+const annotation = "//fcodec:struct"
+
+type fieldKind int
+
+const (
+	fixedField fieldKind = iota
+	stringField
+	bytesField
+	groupField
+)
+
+// How a fixed width type is encoded and decoded. In put %[1]s is the
+// buffer from the field's offset, %[2]s the byte at that offset and
+// %[3]s the field. In get %[1]s and %[2]s are the same.
+type fixedType struct {
+	size int64
+	put  string
+	get  string
+}
+
+var fixedTypes = map[string]fixedType{
+	"bool":    {1, "if %[3]s {\n%[2]s = 1\n} else {\n%[2]s = 0\n}", "%[2]s != 0"},
+	"int8":    {1, "%[2]s = byte(%[3]s)", "int8(%[2]s)"},
+	"uint8":   {1, "%[2]s = %[3]s", "%[2]s"},
+	"byte":    {1, "%[2]s = %[3]s", "%[2]s"},
+	"int16":   {2, "binary.LittleEndian.PutUint16(%[1]s, uint16(%[3]s))", "int16(binary.LittleEndian.Uint16(%[1]s))"},
+	"uint16":  {2, "binary.LittleEndian.PutUint16(%[1]s, %[3]s)", "binary.LittleEndian.Uint16(%[1]s)"},
+	"int32":   {4, "binary.LittleEndian.PutUint32(%[1]s, uint32(%[3]s))", "int32(binary.LittleEndian.Uint32(%[1]s))"},
+	"uint32":  {4, "binary.LittleEndian.PutUint32(%[1]s, %[3]s)", "binary.LittleEndian.Uint32(%[1]s)"},
+	"int64":   {8, "binary.LittleEndian.PutUint64(%[1]s, uint64(%[3]s))", "int64(binary.LittleEndian.Uint64(%[1]s))"},
+	"uint64":  {8, "binary.LittleEndian.PutUint64(%[1]s, %[3]s)", "binary.LittleEndian.Uint64(%[1]s)"},
+	"float32": {4, "binary.LittleEndian.PutUint32(%[1]s, math.Float32bits(%[3]s))", "math.Float32frombits(binary.LittleEndian.Uint32(%[1]s))"},
+	"float64": {8, "binary.LittleEndian.PutUint64(%[1]s, math.Float64bits(%[3]s))", "math.Float64frombits(binary.LittleEndian.Uint64(%[1]s))"},
+}
+
+type field struct {
+	name  string
+	kind  fieldKind
+	fixed fixedType
+	group string
+}
+
+type message struct {
+	name   string
+	fields []field
+}
+
+// The number of bytes every encoding of m uses, i.e. the fixed width
+// fields and the length prefixes
+func (m *message) minSize() int64 {
+	size := int64(0)
+	for _, f := range m.fields {
+		if f.kind == fixedField {
+			size += f.fixed.size
+		} else {
+			size += 4
+		}
+	}
+	return size
+}
+
+func (m *message) fixedSize() bool {
+	for _, f := range m.fields {
+		if f.kind != fixedField {
+			return false
+		}
+	}
+	return true
+}
+
+// Generates the codec methods for every annotated struct in src
+func generate(filename string, src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := findMessages(fset, file)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) == 0 {
+		return nil, errors.New(fmt.Sprintf("No structs annotated with %s in %s", annotation, filename))
+	}
+	names := make(map[string]*message)
+	for _, m := range msgs {
+		names[m.name] = m
+	}
+	for _, m := range msgs {
+		for _, f := range m.fields {
+			if f.kind == groupField && names[f.group] == nil {
+				return nil, errors.New(fmt.Sprintf("%s.%s is a slice of %s, which is not annotated with %s", m.name, f.name, f.group, annotation))
+			}
+		}
+	}
+	body := &bytes.Buffer{}
+	for _, m := range msgs {
+		writeSize(body, m)
+		writeEncode(body, m)
+		writeDecode(body, m, names)
+	}
+	out := &bytes.Buffer{}
+	fmt.Fprintf(out, "// Code generated by fcodecgen. DO NOT EDIT.\n\npackage %s\n\nimport (\n", file.Name.Name)
+	for _, imp := range []struct{ name, path string }{
+		{"binary.", "encoding/binary"},
+		{"math.", "math"},
+	} {
+		if strings.Contains(body.String(), imp.name) {
+			fmt.Fprintf(out, "%q\n", imp.path)
+		}
+	}
+	if strings.Contains(body.String(), "fcodec.") {
+		out.WriteString("\n\"github.com/fmstephe/flib/fcodec\"\n")
+	}
+	out.WriteString(")\n")
+	out.Write(body.Bytes())
+	formatted, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Generated invalid code for %s: %s", filename, err))
+	}
+	return formatted, nil
+}
+
+func findMessages(fset *token.FileSet, file *ast.File) ([]*message, error) {
+	var msgs []*message
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			ts := spec.(*ast.TypeSpec)
+			doc := ts.Doc
+			if doc == nil && len(gen.Specs) == 1 {
+				doc = gen.Doc
+			}
+			if !annotated(doc) {
+				continue
+			}
+			st, ok := ts.Type.(*ast.StructType)
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("%s: %s is annotated but is not a struct", fset.Position(ts.Pos()), ts.Name.Name))
+			}
+			m := &message{name: ts.Name.Name}
+			for _, astField := range st.Fields.List {
+				if len(astField.Names) == 0 {
+					return nil, errors.New(fmt.Sprintf("%s: embedded fields are not supported", fset.Position(astField.Pos())))
+				}
+				f, err := newField(astField.Type)
+				if err != nil {
+					return nil, errors.New(fmt.Sprintf("%s: %s.%s %s", fset.Position(astField.Pos()), m.name, astField.Names[0].Name, err))
+				}
+				for _, name := range astField.Names {
+					f.name = name.Name
+					m.fields = append(m.fields, f)
+				}
+			}
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs, nil
+}
+
+func annotated(doc *ast.CommentGroup) bool {
+	if doc == nil {
+		return false
+	}
+	for _, c := range doc.List {
+		if strings.TrimSpace(c.Text) == annotation {
+			return true
+		}
+	}
+	return false
+}
+
+func newField(expr ast.Expr) (field, error) {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if t.Name == "string" {
+			return field{kind: stringField}, nil
+		}
+		if fixed, ok := fixedTypes[t.Name]; ok {
+			return field{kind: fixedField, fixed: fixed}, nil
+		}
+		if t.Name == "int" || t.Name == "uint" || t.Name == "uintptr" {
+			return field{}, errors.New(fmt.Sprintf("has type %s, use a fixed width integer", t.Name))
+		}
+	case *ast.ArrayType:
+		elem, ok := t.Elt.(*ast.Ident)
+		if t.Len == nil && ok {
+			if elem.Name == "byte" || elem.Name == "uint8" {
+				return field{kind: bytesField}, nil
+			}
+			if _, fixed := fixedTypes[elem.Name]; !fixed && elem.Name != "string" {
+				return field{kind: groupField, group: elem.Name}, nil
+			}
+		}
+	}
+	return field{}, errors.New(fmt.Sprintf("has unsupported type %s", exprString(expr)))
+}
+
+func exprString(expr ast.Expr) string {
+	b := &bytes.Buffer{}
+	format.Node(b, token.NewFileSet(), expr)
+	return b.String()
+}
+
+func writeSize(w *bytes.Buffer, m *message) {
+	fmt.Fprintf(w, "\nfunc (m *%s) Size() int64 {\n", m.name)
+	if m.fixedSize() {
+		fmt.Fprintf(w, "return %d\n}\n", m.minSize())
+		return
+	}
+	fmt.Fprintf(w, "size := int64(%d)\n", m.minSize())
+	for _, f := range m.fields {
+		switch f.kind {
+		case stringField, bytesField:
+			fmt.Fprintf(w, "size += int64(len(m.%s))\n", f.name)
+		case groupField:
+			fmt.Fprintf(w, "for i := range m.%s {\nsize += m.%s[i].Size()\n}\n", f.name, f.name)
+		}
+	}
+	w.WriteString("return size\n}\n")
+}
+
+func writeEncode(w *bytes.Buffer, m *message) {
+	fmt.Fprintf(w, "\nfunc (m *%s) EncodeTo(b []byte) int64 {\n", m.name)
+	if len(m.fields) == 0 {
+		w.WriteString("return 0\n}\n")
+		return
+	}
+	w.WriteString("i := int64(0)\n")
+	for _, f := range m.fields {
+		switch f.kind {
+		case fixedField:
+			fmt.Fprintf(w, f.fixed.put+"\n", "b[i:]", "b[i]", "m."+f.name)
+			fmt.Fprintf(w, "i += %d\n", f.fixed.size)
+		case stringField:
+			fmt.Fprintf(w, "i += fcodec.PutString(b[i:], m.%s)\n", f.name)
+		case bytesField:
+			fmt.Fprintf(w, "i += fcodec.PutBytes(b[i:], m.%s)\n", f.name)
+		case groupField:
+			fmt.Fprintf(w, "binary.LittleEndian.PutUint32(b[i:], uint32(len(m.%s)))\n", f.name)
+			w.WriteString("i += 4\n")
+			fmt.Fprintf(w, "for j := range m.%s {\ni += m.%s[j].EncodeTo(b[i:])\n}\n", f.name, f.name)
+		}
+	}
+	w.WriteString("return i\n}\n")
+}
+
+func writeDecode(w *bytes.Buffer, m *message, names map[string]*message) {
+	fmt.Fprintf(w, "\nfunc (m *%s) DecodeFrom(b []byte) (int64, error) {\n", m.name)
+	if len(m.fields) == 0 {
+		w.WriteString("return 0, nil\n}\n")
+		return
+	}
+	w.WriteString("i := int64(0)\n")
+	for _, f := range m.fields {
+		name := fmt.Sprintf("%q", m.name+"."+f.name)
+		switch f.kind {
+		case fixedField:
+			fmt.Fprintf(w, "if err := fcodec.Check(b, i, %d, %s); err != nil {\nreturn i, err\n}\n", f.fixed.size, name)
+			fmt.Fprintf(w, "m.%s = %s\n", f.name, fmt.Sprintf(f.fixed.get, "b[i:]", "b[i]"))
+			fmt.Fprintf(w, "i += %d\n", f.fixed.size)
+		case stringField:
+			fmt.Fprintf(w, "{\nv, n, err := fcodec.GetString(b[i:], %s)\nif err != nil {\nreturn i, err\n}\nm.%s = v\ni += n\n}\n", name, f.name)
+		case bytesField:
+			fmt.Fprintf(w, "{\nv, n, err := fcodec.GetBytes(b[i:], m.%s[:0], %s)\nif err != nil {\nreturn i, err\n}\nm.%s = v\ni += n\n}\n", f.name, name, f.name)
+		case groupField:
+			fmt.Fprintf(w, "if err := fcodec.Check(b, i, 4, %s); err != nil {\nreturn i, err\n}\n", name)
+			w.WriteString("{\ncount := int64(binary.LittleEndian.Uint32(b[i:]))\ni += 4\n")
+			// Reject counts which can't fit before allocating the slice
+			if min := names[f.group].minSize(); min > 0 {
+				fmt.Fprintf(w, "if err := fcodec.Check(b, i, count*%d, %s); err != nil {\nreturn i, err\n}\n", min, name)
+			}
+			fmt.Fprintf(w, "m.%s = fcodec.Resize(m.%s, int(count))\n", f.name, f.name)
+			fmt.Fprintf(w, "for j := range m.%s {\nn, err := m.%s[j].DecodeFrom(b[i:])\ni += n\nif err != nil {\nreturn i, err\n}\n}\n}\n", f.name, f.name)
+		}
+	}
+	w.WriteString("return i, nil\n}\n")
+}
@@ -0,0 +1,55 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+// Fcodecgen generates Size, EncodeTo and DecodeFrom methods for structs
+// annotated with a //fcodec:struct comment. See package fcodec for the
+// encoding.
+//
+// Supported field types are bool, the fixed width integers, float32,
+// float64, string, []byte and slices of other annotated structs in the
+// same file.
+//
+// Usually run by go generate, from the file declaring the structs
+//
+//	//go:generate go run github.com/fmstephe/flib/fcodec/fcodecgen
+//
+// which writes the methods to <file>_fcodec.go.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	in  = flag.String("in", os.Getenv("GOFILE"), "The Go file declaring the annotated structs, defaults to $GOFILE")
+	out = flag.String("out", "", "The file to write the generated code to, defaults to <in>_fcodec.go")
+)
+
+func main() {
+	flag.Parse()
+	if *in == "" {
+		fmt.Fprintln(os.Stderr, "fcodecgen: no input file, set -in or run with go generate")
+		os.Exit(2)
+	}
+	if *out == "" {
+		*out = strings.TrimSuffix(*in, ".go") + "_fcodec.go"
+	}
+	src, err := os.ReadFile(*in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fcodecgen: %s\n", err)
+		os.Exit(1)
+	}
+	code, err := generate(*in, src)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fcodecgen: %s\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(*out, code, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "fcodecgen: %s\n", err)
+		os.Exit(1)
+	}
+}
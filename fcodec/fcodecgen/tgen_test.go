@@ -0,0 +1,72 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// The generated code for testmsg is checked in, and serves as the
+// golden file. After changing the generator run go generate in
+// fcodec/internal/testmsg.
+func TestGolden(t *testing.T) {
+	src, err := os.ReadFile("../internal/testmsg/msg.go")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	golden, err := os.ReadFile("../internal/testmsg/msg_fcodec.go")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	code, err := generate("msg.go", src)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(code, golden) {
+		t.Errorf("Generated code does not match msg_fcodec.go, run go generate\n%s", code)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src string
+		err string
+	}{
+		{"package p\ntype A struct { X int64 }", "No structs annotated"},
+		{"package p\n//fcodec:struct\ntype A struct { X int }", "A.X has type int, use a fixed width integer"},
+		{"package p\n//fcodec:struct\ntype A struct { X map[string]int64 }", "A.X has unsupported type map[string]int64"},
+		{"package p\n//fcodec:struct\ntype A struct { X []string }", "A.X has unsupported type []string"},
+		{"package p\n//fcodec:struct\ntype A struct { X [4]byte }", "A.X has unsupported type [4]byte"},
+		{"package p\n//fcodec:struct\ntype A struct { X []B }\ntype B struct {}", "A.X is a slice of B, which is not annotated"},
+		{"package p\n//fcodec:struct\ntype A int64", "A is annotated but is not a struct"},
+		{"package p\ntype B struct{}\n//fcodec:struct\ntype A struct { B }", "embedded fields are not supported"},
+	} {
+		_, err := generate("p.go", []byte(tc.src))
+		if err == nil {
+			t.Errorf("Expected error containing %q for\n%s", tc.err, tc.src)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("Expected error containing %q, found %q", tc.err, err.Error())
+		}
+	}
+}
+
+func TestGenerateGroupedTypes(t *testing.T) {
+	src := "package p\ntype (\n//fcodec:struct\nA struct { X int64 }\nB struct { Y int64 }\n)"
+	code, err := generate("p.go", []byte(src))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !strings.Contains(string(code), "func (m *A) Size()") {
+		t.Errorf("Expected methods for A\n%s", code)
+	}
+	if strings.Contains(string(code), "func (m *B)") {
+		t.Errorf("Unexpected methods for B\n%s", code)
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+// Package testmsg holds structs used to test fcodecgen. The generated
+// msg_fcodec.go is also the golden file for fcodecgen's tests.
+package testmsg
+
+//go:generate go run github.com/fmstephe/flib/fcodec/fcodecgen
+
+//fcodec:struct
+type Order struct {
+	ID       int64
+	Price    float64
+	Quantity uint32
+	Side     byte
+	Active   bool
+	Symbol   string
+	Fills    []Fill
+	Note     []byte
+}
+
+//fcodec:struct
+type Fill struct {
+	Venue      int8
+	Flags      uint16
+	Qty        int32
+	Ratio      float32
+	Counter    int16
+	Seq, Trade uint64
+}
+
+//fcodec:struct
+type Empty struct {
+}
+
+// Not annotated, no methods are generated
+type Ignored struct {
+	Size int
+}
@@ -0,0 +1,185 @@
+// Code generated by fcodecgen. DO NOT EDIT.
+
+package testmsg
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/fmstephe/flib/fcodec"
+)
+
+func (m *Order) Size() int64 {
+	size := int64(34)
+	size += int64(len(m.Symbol))
+	for i := range m.Fills {
+		size += m.Fills[i].Size()
+	}
+	size += int64(len(m.Note))
+	return size
+}
+
+func (m *Order) EncodeTo(b []byte) int64 {
+	i := int64(0)
+	binary.LittleEndian.PutUint64(b[i:], uint64(m.ID))
+	i += 8
+	binary.LittleEndian.PutUint64(b[i:], math.Float64bits(m.Price))
+	i += 8
+	binary.LittleEndian.PutUint32(b[i:], m.Quantity)
+	i += 4
+	b[i] = m.Side
+	i += 1
+	if m.Active {
+		b[i] = 1
+	} else {
+		b[i] = 0
+	}
+	i += 1
+	i += fcodec.PutString(b[i:], m.Symbol)
+	binary.LittleEndian.PutUint32(b[i:], uint32(len(m.Fills)))
+	i += 4
+	for j := range m.Fills {
+		i += m.Fills[j].EncodeTo(b[i:])
+	}
+	i += fcodec.PutBytes(b[i:], m.Note)
+	return i
+}
+
+func (m *Order) DecodeFrom(b []byte) (int64, error) {
+	i := int64(0)
+	if err := fcodec.Check(b, i, 8, "Order.ID"); err != nil {
+		return i, err
+	}
+	m.ID = int64(binary.LittleEndian.Uint64(b[i:]))
+	i += 8
+	if err := fcodec.Check(b, i, 8, "Order.Price"); err != nil {
+		return i, err
+	}
+	m.Price = math.Float64frombits(binary.LittleEndian.Uint64(b[i:]))
+	i += 8
+	if err := fcodec.Check(b, i, 4, "Order.Quantity"); err != nil {
+		return i, err
+	}
+	m.Quantity = binary.LittleEndian.Uint32(b[i:])
+	i += 4
+	if err := fcodec.Check(b, i, 1, "Order.Side"); err != nil {
+		return i, err
+	}
+	m.Side = b[i]
+	i += 1
+	if err := fcodec.Check(b, i, 1, "Order.Active"); err != nil {
+		return i, err
+	}
+	m.Active = b[i] != 0
+	i += 1
+	{
+		v, n, err := fcodec.GetString(b[i:], "Order.Symbol")
+		if err != nil {
+			return i, err
+		}
+		m.Symbol = v
+		i += n
+	}
+	if err := fcodec.Check(b, i, 4, "Order.Fills"); err != nil {
+		return i, err
+	}
+	{
+		count := int64(binary.LittleEndian.Uint32(b[i:]))
+		i += 4
+		if err := fcodec.Check(b, i, count*29, "Order.Fills"); err != nil {
+			return i, err
+		}
+		m.Fills = fcodec.Resize(m.Fills, int(count))
+		for j := range m.Fills {
+			n, err := m.Fills[j].DecodeFrom(b[i:])
+			i += n
+			if err != nil {
+				return i, err
+			}
+		}
+	}
+	{
+		v, n, err := fcodec.GetBytes(b[i:], m.Note[:0], "Order.Note")
+		if err != nil {
+			return i, err
+		}
+		m.Note = v
+		i += n
+	}
+	return i, nil
+}
+
+func (m *Fill) Size() int64 {
+	return 29
+}
+
+func (m *Fill) EncodeTo(b []byte) int64 {
+	i := int64(0)
+	b[i] = byte(m.Venue)
+	i += 1
+	binary.LittleEndian.PutUint16(b[i:], m.Flags)
+	i += 2
+	binary.LittleEndian.PutUint32(b[i:], uint32(m.Qty))
+	i += 4
+	binary.LittleEndian.PutUint32(b[i:], math.Float32bits(m.Ratio))
+	i += 4
+	binary.LittleEndian.PutUint16(b[i:], uint16(m.Counter))
+	i += 2
+	binary.LittleEndian.PutUint64(b[i:], m.Seq)
+	i += 8
+	binary.LittleEndian.PutUint64(b[i:], m.Trade)
+	i += 8
+	return i
+}
+
+func (m *Fill) DecodeFrom(b []byte) (int64, error) {
+	i := int64(0)
+	if err := fcodec.Check(b, i, 1, "Fill.Venue"); err != nil {
+		return i, err
+	}
+	m.Venue = int8(b[i])
+	i += 1
+	if err := fcodec.Check(b, i, 2, "Fill.Flags"); err != nil {
+		return i, err
+	}
+	m.Flags = binary.LittleEndian.Uint16(b[i:])
+	i += 2
+	if err := fcodec.Check(b, i, 4, "Fill.Qty"); err != nil {
+		return i, err
+	}
+	m.Qty = int32(binary.LittleEndian.Uint32(b[i:]))
+	i += 4
+	if err := fcodec.Check(b, i, 4, "Fill.Ratio"); err != nil {
+		return i, err
+	}
+	m.Ratio = math.Float32frombits(binary.LittleEndian.Uint32(b[i:]))
+	i += 4
+	if err := fcodec.Check(b, i, 2, "Fill.Counter"); err != nil {
+		return i, err
+	}
+	m.Counter = int16(binary.LittleEndian.Uint16(b[i:]))
+	i += 2
+	if err := fcodec.Check(b, i, 8, "Fill.Seq"); err != nil {
+		return i, err
+	}
+	m.Seq = binary.LittleEndian.Uint64(b[i:])
+	i += 8
+	if err := fcodec.Check(b, i, 8, "Fill.Trade"); err != nil {
+		return i, err
+	}
+	m.Trade = binary.LittleEndian.Uint64(b[i:])
+	i += 8
+	return i, nil
+}
+
+func (m *Empty) Size() int64 {
+	return 0
+}
+
+func (m *Empty) EncodeTo(b []byte) int64 {
+	return 0
+}
+
+func (m *Empty) DecodeFrom(b []byte) (int64, error) {
+	return 0, nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package testmsg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fmstephe/flib/fcodec"
+	"github.com/fmstephe/flib/queues/spscq"
+)
+
+func testOrder() *Order {
+	return &Order{
+		ID:       -7,
+		Price:    101.25,
+		Quantity: 300,
+		Side:     'B',
+		Active:   true,
+		Symbol:   "FLIB",
+		Fills: []Fill{
+			{Venue: -1, Flags: 0xBEEF, Qty: -100, Ratio: 0.5, Counter: -3, Seq: 1, Trade: 1 << 60},
+			{Venue: 2, Qty: 200, Ratio: -1.5, Seq: 2},
+		},
+		Note: []byte("a note"),
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, o := range []*Order{testOrder(), {}} {
+		b := make([]byte, o.Size())
+		if n := o.EncodeTo(b); n != o.Size() {
+			t.Errorf("Expected to encode %d bytes, encoded %d", o.Size(), n)
+		}
+		decoded := &Order{}
+		n, err := decoded.DecodeFrom(b)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if n != o.Size() {
+			t.Errorf("Expected to decode %d bytes, decoded %d", o.Size(), n)
+		}
+		// Decoding leaves empty, rather than nil, slices
+		if len(o.Fills) == 0 {
+			decoded.Fills = o.Fills
+		}
+		if len(o.Note) == 0 {
+			decoded.Note = o.Note
+		}
+		if !reflect.DeepEqual(o, decoded) {
+			t.Errorf("Expected %v, decoded %v", o, decoded)
+		}
+	}
+}
+
+func TestLayout(t *testing.T) {
+	f := &Fill{Venue: 1, Flags: 0x0302, Qty: 0x07060504}
+	b := make([]byte, f.Size())
+	f.EncodeTo(b)
+	expected := []byte{1, 2, 3, 4, 5, 6, 7}
+	if !reflect.DeepEqual(b[:len(expected)], expected) {
+		t.Errorf("Expected little-endian fields %v, found %v", expected, b[:len(expected)])
+	}
+}
+
+func TestDecodeShortBuffer(t *testing.T) {
+	o := testOrder()
+	b := make([]byte, o.Size())
+	o.EncodeTo(b)
+	for i := range b {
+		decoded := &Order{}
+		if _, err := decoded.DecodeFrom(b[:i]); err == nil {
+			t.Errorf("Expected error decoding %d of %d bytes", i, len(b))
+		}
+	}
+}
+
+func TestDecodeBadCount(t *testing.T) {
+	o := &Order{}
+	b := make([]byte, o.Size())
+	o.EncodeTo(b)
+	// Claim a huge number of fills
+	b[26], b[27], b[28], b[29] = 0xFF, 0xFF, 0xFF, 0xFF
+	if _, err := o.DecodeFrom(b); err == nil {
+		t.Errorf("Expected error decoding impossible fill count")
+	}
+}
+
+func TestDecodeDoesNotAllocate(t *testing.T) {
+	o := testOrder()
+	o.Symbol = ""
+	b := make([]byte, o.Size())
+	o.EncodeTo(b)
+	decoded := &Order{}
+	decoded.DecodeFrom(b)
+	allocs := testing.AllocsPerRun(100, func() {
+		decoded.DecodeFrom(b)
+	})
+	if allocs != 0 {
+		t.Errorf("Expected decoding into a reused Order not to allocate, found %f allocations", allocs)
+	}
+}
+
+func TestByteMsgQ(t *testing.T) {
+	q, err := spscq.NewByteMsgQ(1024, 0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	o := testOrder()
+	if !fcodec.Write(q, o) {
+		t.Fatal("Unable to write to empty queue")
+	}
+	if !fcodec.WriteLazy(q, &Fill{Seq: 9}) {
+		t.Fatal("Unable to write to queue")
+	}
+	decoded := &Order{}
+	if ok, err := fcodec.Read(q, decoded); !ok || err != nil {
+		t.Fatalf("Expected to read order, found %t %v", ok, err)
+	}
+	if !reflect.DeepEqual(o, decoded) {
+		t.Errorf("Expected %v, decoded %v", o, decoded)
+	}
+	fill := &Fill{}
+	if ok, err := fcodec.ReadLazy(q, fill); !ok || err != nil || fill.Seq != 9 {
+		t.Fatalf("Expected to read fill, found %t %v %v", ok, err, fill)
+	}
+	if ok, _ := fcodec.Read(q, fill); ok {
+		t.Errorf("Expected empty queue")
+	}
+	big := &Order{Note: make([]byte, 2048)}
+	if fcodec.Write(q, big) {
+		t.Errorf("Expected write larger than the queue to fail")
+	}
+}
@@ -0,0 +1,19 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+//go:build !purego
+// +build !purego
+
+package fcpu
+
+const hasCPUID = true
+
+func cpuid(leaf, subleaf uint32) (eax, ebx, ecx, edx uint32)
+
+// Reads XCR0, only valid if CPUID reports OSXSAVE
+func xgetbv() (xcr0 uint64)
+
+func detect() Info {
+	return detectX86(cpuid, xgetbv)
+}
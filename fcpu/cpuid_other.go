@@ -0,0 +1,29 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+//go:build !amd64 || purego
+// +build !amd64 purego
+
+package fcpu
+
+const hasCPUID = false
+
+func cpuid(leaf, subleaf uint32) (eax, ebx, ecx, edx uint32) {
+	return 0, 0, 0, 0
+}
+
+// Without CPUID only what Linux reports in sysfs is available
+func detect() Info {
+	var i Info
+	i.Caches = sysfsCaches(sysfsCPU0)
+	for _, c := range i.Caches {
+		if c.Level == 1 && c.Type != InstructionCache {
+			i.CacheLineBytes = c.LineBytes
+			break
+		}
+	}
+	i.ThreadsPerCore = sysfsThreadsPerCore(sysfsCPU0)
+	i.HyperThreading = i.ThreadsPerCore > 1
+	return i
+}
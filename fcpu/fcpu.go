@@ -0,0 +1,113 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+// Package fcpu reports the features, caches and topology of the CPU we
+// are running on. On AMD64 CPUs this is read using CPUID, elsewhere on
+// Linux the caches are read from sysfs and everything else is left
+// unreported.
+package fcpu
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	DataCache        = "Data"
+	InstructionCache = "Instruction"
+	UnifiedCache     = "Unified"
+)
+
+type Cache struct {
+	Level     int
+	Type      string
+	Bytes     int
+	LineBytes int
+	Ways      int
+	Sets      int
+	// The number of logical processors sharing this cache, 0 if unknown
+	SharedBy int
+}
+
+func (c Cache) String() string {
+	return fmt.Sprintf("L%d %s %dKB (%d byte lines, %d ways)", c.Level, c.Type, c.Bytes/1024, c.LineBytes, c.Ways)
+}
+
+type Info struct {
+	Vendor   string
+	Brand    string
+	Family   int
+	Model    int
+	Stepping int
+	// The size of the cache lines of the L1 data cache, 0 if unknown
+	CacheLineBytes int
+	Caches         []Cache
+	// The number of logical processors per physical core, 0 if unknown
+	ThreadsPerCore int
+	HyperThreading bool
+	// The TSC ticks at a constant rate, whatever the CPU frequency
+	ConstantTSC bool
+	// As ConstantTSC, and the TSC keeps ticking in deep sleep states
+	InvariantTSC bool
+	RDTSCP       bool
+	// AVX2 and AVX512F are only reported if the operating system also
+	// saves the AVX registers
+	AVX2    bool
+	AVX512F bool
+	BMI2    bool
+}
+
+var (
+	detectOnce sync.Once
+	info       Info
+)
+
+// Returns the details of the CPU we are running on. The CPU is
+// inspected on the first call only. The Caches slice is shared and
+// must not be modified.
+func Detect() Info {
+	detectOnce.Do(func() {
+		info = detect()
+	})
+	return info
+}
+
+// Returns the cache line size of the L1 data cache, or 0 if unknown.
+func CacheLineBytes() int {
+	return Detect().CacheLineBytes
+}
+
+// Indicates whether CPUID() can be used.
+func HasCPUID() bool {
+	return hasCPUID
+}
+
+// Executes the CPUID instruction with EAX=leaf and ECX=subleaf. Where
+// CPUID is not available, see HasCPUID(), every register is 0.
+func CPUID(leaf, subleaf uint32) (eax, ebx, ecx, edx uint32) {
+	return cpuid(leaf, subleaf)
+}
+
+// A multi-line description, suitable for printing alongside benchmark
+// results.
+func (i Info) String() string {
+	var b strings.Builder
+	vendor, brand := i.Vendor, i.Brand
+	if vendor == "" {
+		vendor = "unknown"
+	}
+	if brand == "" {
+		brand = "unknown"
+	}
+	fmt.Fprintf(&b, "CPU        %s (%s) family %d model %d stepping %d\n", brand, vendor, i.Family, i.Model, i.Stepping)
+	fmt.Fprintf(&b, "Cache line %d bytes\n", i.CacheLineBytes)
+	for _, c := range i.Caches {
+		fmt.Fprintf(&b, "Cache      %s\n", c)
+	}
+	fmt.Fprintf(&b, "Threads    %d per core, hyperthreading %t\n", i.ThreadsPerCore, i.HyperThreading)
+	fmt.Fprintf(&b, "TSC        constant %t, invariant %t, rdtscp %t\n", i.ConstantTSC, i.InvariantTSC, i.RDTSCP)
+	fmt.Fprintf(&b, "Features   avx2 %t, avx512f %t, bmi2 %t\n", i.AVX2, i.AVX512F, i.BMI2)
+	return b.String()
+}
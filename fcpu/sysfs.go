@@ -0,0 +1,101 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package fcpu
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const sysfsCPU0 = "/sys/devices/system/cpu/cpu0"
+
+// Reads cpu0's caches from sysfs, as laid out on Linux under
+// cache/index*. Anything which can't be read is left as 0, if there is
+// no such directory no caches are returned.
+func sysfsCaches(cpuDir string) []Cache {
+	dirs, _ := filepath.Glob(filepath.Join(cpuDir, "cache", "index*"))
+	var caches []Cache
+	for _, dir := range dirs {
+		c := Cache{
+			Level:     sysfsInt(dir, "level"),
+			LineBytes: sysfsInt(dir, "coherency_line_size"),
+			Ways:      sysfsInt(dir, "ways_of_associativity"),
+			Sets:      sysfsInt(dir, "number_of_sets"),
+			Bytes:     sysfsSize(dir, "size"),
+		}
+		switch sysfsString(dir, "type") {
+		case "Data":
+			c.Type = DataCache
+		case "Instruction":
+			c.Type = InstructionCache
+		case "Unified":
+			c.Type = UnifiedCache
+		default:
+			continue
+		}
+		if shared := sysfsString(dir, "shared_cpu_list"); shared != "" {
+			c.SharedBy = cpuListLen(shared)
+		}
+		caches = append(caches, c)
+	}
+	return caches
+}
+
+// Reads the number of logical processors sharing cpu0's core, 0 if
+// unknown.
+func sysfsThreadsPerCore(cpuDir string) int {
+	siblings := sysfsString(filepath.Join(cpuDir, "topology"), "thread_siblings_list")
+	if siblings == "" {
+		return 0
+	}
+	return cpuListLen(siblings)
+}
+
+func sysfsString(dir, name string) string {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func sysfsInt(dir, name string) int {
+	i, _ := strconv.Atoi(sysfsString(dir, name))
+	return i
+}
+
+// Sizes are written as e.g. 32K or 8M
+func sysfsSize(dir, name string) int {
+	s := sysfsString(dir, name)
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1024
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1024 * 1024
+	}
+	i, _ := strconv.Atoi(strings.TrimRight(s, "KM"))
+	return i * multiplier
+}
+
+// Counts the processors in a list such as 0-3,8-11
+func cpuListLen(list string) int {
+	count := 0
+	for _, r := range strings.Split(list, ",") {
+		from, to, found := strings.Cut(r, "-")
+		if !found {
+			count++
+			continue
+		}
+		f, err1 := strconv.Atoi(from)
+		t, err2 := strconv.Atoi(to)
+		if err1 == nil && err2 == nil && t >= f {
+			count += t - f + 1
+		}
+	}
+	return count
+}
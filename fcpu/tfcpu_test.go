@@ -0,0 +1,203 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package fcpu
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A CPUID table for a fake CPU, keyed by leaf and subleaf. Leaves
+// which are missing return 0 in every register.
+type fakeCPU map[[2]uint32][4]uint32
+
+func (f fakeCPU) cpuid(leaf, subleaf uint32) (eax, ebx, ecx, edx uint32) {
+	r := f[[2]uint32{leaf, subleaf}]
+	return r[0], r[1], r[2], r[3]
+}
+
+// Splits s into little endian registers, padding with NULs
+func registers(s string) []uint32 {
+	b := make([]byte, (len(s)+3)/4*4)
+	copy(b, s)
+	regs := make([]uint32, len(b)/4)
+	for i := range regs {
+		regs[i] = binary.LittleEndian.Uint32(b[4*i:])
+	}
+	return regs
+}
+
+func (f fakeCPU) setVendor(vendor string, maxLeaf, maxExtLeaf uint32) {
+	r := registers(vendor)
+	f[[2]uint32{0, 0}] = [4]uint32{maxLeaf, r[0], r[2], r[1]}
+	f[[2]uint32{0x80000000, 0}] = [4]uint32{maxExtLeaf, 0, 0, 0}
+}
+
+func (f fakeCPU) setBrand(brand string) {
+	r := registers(brand)
+	for len(r) < 12 {
+		r = append(r, 0)
+	}
+	for i := uint32(0); i < 3; i++ {
+		f[[2]uint32{0x80000002 + i, 0}] = [4]uint32{r[4*i], r[4*i+1], r[4*i+2], r[4*i+3]}
+	}
+}
+
+// Encodes a leaf 4 style cache description
+func cacheRegisters(cacheType, level, sharedBy, lineBytes, ways, sets uint32) [4]uint32 {
+	eax := cacheType | level<<5 | (sharedBy-1)<<14
+	ebx := (lineBytes - 1) | (ways-1)<<22
+	return [4]uint32{eax, ebx, sets - 1, 0}
+}
+
+func newIntelCPU() fakeCPU {
+	f := fakeCPU{}
+	f.setVendor(intel, 0xB, 0x80000008)
+	f.setBrand("  Fake Intel CPU @ 3.00GHz")
+	// Family 6, extended model 0x5, model 0xE, stepping 3. CLFLUSH 8*8
+	// bytes, OSXSAVE and HTT
+	f[[2]uint32{1, 0}] = [4]uint32{0x506E3, 8 << 8, 1 << 27, 1 << 28}
+	// AVX2, BMI2 and AVX512F
+	f[[2]uint32{7, 0}] = [4]uint32{0, 1<<5 | 1<<8 | 1<<16, 0, 0}
+	f[[2]uint32{4, 0}] = cacheRegisters(1, 1, 2, 64, 8, 64)
+	f[[2]uint32{4, 1}] = cacheRegisters(2, 1, 2, 64, 8, 64)
+	f[[2]uint32{4, 2}] = cacheRegisters(3, 2, 2, 64, 4, 1024)
+	// Two threads at the SMT level
+	f[[2]uint32{0xB, 0}] = [4]uint32{1, 2, 1 << 8, 0}
+	f[[2]uint32{0x80000001, 0}] = [4]uint32{0, 0, 0, 1 << 27}
+	f[[2]uint32{0x80000007, 0}] = [4]uint32{0, 0, 0, 1 << 8}
+	return f
+}
+
+func TestDetectIntel(t *testing.T) {
+	// YMM state is saved, but not the AVX-512 state
+	i := detectX86(newIntelCPU().cpuid, func() uint64 { return 0x7 })
+	if i.Vendor != intel || i.Brand != "Fake Intel CPU @ 3.00GHz" {
+		t.Errorf("Unexpected vendor %q and brand %q", i.Vendor, i.Brand)
+	}
+	if i.Family != 6 || i.Model != 0x5E || i.Stepping != 3 {
+		t.Errorf("Unexpected family %d, model %d, stepping %d", i.Family, i.Model, i.Stepping)
+	}
+	if i.CacheLineBytes != 64 {
+		t.Errorf("Expected 64 byte cache lines, found %d", i.CacheLineBytes)
+	}
+	expected := []Cache{
+		{Level: 1, Type: DataCache, Bytes: 32 * 1024, LineBytes: 64, Ways: 8, Sets: 64, SharedBy: 2},
+		{Level: 1, Type: InstructionCache, Bytes: 32 * 1024, LineBytes: 64, Ways: 8, Sets: 64, SharedBy: 2},
+		{Level: 2, Type: UnifiedCache, Bytes: 256 * 1024, LineBytes: 64, Ways: 4, Sets: 1024, SharedBy: 2},
+	}
+	if len(i.Caches) != len(expected) {
+		t.Fatalf("Expected caches %v, found %v", expected, i.Caches)
+	}
+	for j := range expected {
+		if i.Caches[j] != expected[j] {
+			t.Errorf("Expected cache %v, found %v", expected[j], i.Caches[j])
+		}
+	}
+	if i.ThreadsPerCore != 2 || !i.HyperThreading {
+		t.Errorf("Expected 2 threads per core, found %d", i.ThreadsPerCore)
+	}
+	if !i.ConstantTSC || !i.InvariantTSC || !i.RDTSCP {
+		t.Errorf("Unexpected TSC features %v", i)
+	}
+	if !i.AVX2 || !i.BMI2 || i.AVX512F {
+		t.Errorf("Expected AVX2 and BMI2, without AVX512F, found %t %t %t", i.AVX2, i.BMI2, i.AVX512F)
+	}
+}
+
+func TestDetectAVXNotSaved(t *testing.T) {
+	f := newIntelCPU()
+	// Without OSXSAVE XCR0 can't be read
+	f[[2]uint32{1, 0}] = [4]uint32{0x506E3, 8 << 8, 0, 0}
+	i := detectX86(f.cpuid, func() uint64 {
+		t.Fatalf("xgetbv called without OSXSAVE")
+		return 0
+	})
+	if i.AVX2 || i.AVX512F || !i.BMI2 {
+		t.Errorf("Expected only BMI2, found %t %t %t", i.AVX2, i.AVX512F, i.BMI2)
+	}
+}
+
+func TestDetectAMD(t *testing.T) {
+	f := fakeCPU{}
+	f.setVendor(amd, 0x7, 0x8000001E)
+	// Family 0xF + 0x8, model 0x1 + extended 0x7, stepping 0
+	f[[2]uint32{1, 0}] = [4]uint32{0x870F10, 8 << 8, 0, 0}
+	f[[2]uint32{0x8000001D, 0}] = cacheRegisters(1, 1, 2, 64, 8, 64)
+	f[[2]uint32{0x8000001E, 0}] = [4]uint32{0, 1 << 8, 0, 0}
+	i := detectX86(f.cpuid, func() uint64 { return 0 })
+	if i.Family != 0x17 || i.Model != 0x71 {
+		t.Errorf("Unexpected family %x, model %x", i.Family, i.Model)
+	}
+	if len(i.Caches) != 1 || i.CacheLineBytes != 64 {
+		t.Errorf("Expected a single cache with 64 byte lines, found %v", i.Caches)
+	}
+	if i.ThreadsPerCore != 2 || !i.HyperThreading {
+		t.Errorf("Expected 2 threads per core, found %d", i.ThreadsPerCore)
+	}
+	// No invariant TSC, but AMD family 0x10 onwards has a constant TSC
+	if !i.ConstantTSC || i.InvariantTSC {
+		t.Errorf("Expected constant, not invariant, TSC")
+	}
+}
+
+func TestSysfsCaches(t *testing.T) {
+	dir := t.TempDir()
+	write := func(path, value string) {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := os.WriteFile(path, []byte(value+"\n"), 0644); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	write("cache/index0/level", "1")
+	write("cache/index0/type", "Data")
+	write("cache/index0/size", "64K")
+	write("cache/index0/coherency_line_size", "128")
+	write("cache/index0/ways_of_associativity", "8")
+	write("cache/index0/number_of_sets", "64")
+	write("cache/index0/shared_cpu_list", "0")
+	write("cache/index1/level", "2")
+	write("cache/index1/type", "Unified")
+	write("cache/index1/size", "4M")
+	write("cache/index1/shared_cpu_list", "0-3,8-11")
+	write("topology/thread_siblings_list", "0,4")
+	caches := sysfsCaches(dir)
+	expected := []Cache{
+		{Level: 1, Type: DataCache, Bytes: 64 * 1024, LineBytes: 128, Ways: 8, Sets: 64, SharedBy: 1},
+		{Level: 2, Type: UnifiedCache, Bytes: 4 * 1024 * 1024, SharedBy: 8},
+	}
+	if len(caches) != len(expected) {
+		t.Fatalf("Expected caches %v, found %v", expected, caches)
+	}
+	for i := range expected {
+		if caches[i] != expected[i] {
+			t.Errorf("Expected cache %v, found %v", expected[i], caches[i])
+		}
+	}
+	if threads := sysfsThreadsPerCore(dir); threads != 2 {
+		t.Errorf("Expected 2 threads per core, found %d", threads)
+	}
+	if caches := sysfsCaches(filepath.Join(dir, "missing")); caches != nil {
+		t.Errorf("Expected no caches, found %v", caches)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	i := Detect()
+	if size := i.CacheLineBytes; size != 0 && size&(size-1) != 0 {
+		t.Errorf("Cache line size %d is not a power of two", size)
+	}
+	if HasCPUID() && i.Vendor == "" {
+		t.Errorf("Expected a vendor from CPUID")
+	}
+	if i.String() == "" {
+		t.Errorf("Expected a description")
+	}
+}
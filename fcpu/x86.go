@@ -0,0 +1,156 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package fcpu
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+type cpuidFunc func(leaf, subleaf uint32) (eax, ebx, ecx, edx uint32)
+
+const (
+	intel = "GenuineIntel"
+	amd   = "AuthenticAMD"
+)
+
+// Decodes the results of cpuid. xgetbv reads the XCR0 register, which
+// records which registers the operating system saves, it is only called
+// if CPUID reports OSXSAVE.
+func detectX86(cpuid cpuidFunc, xgetbv func() uint64) Info {
+	var i Info
+	maxLeaf, ebx, ecx, edx := cpuid(0, 0)
+	i.Vendor = registerString(ebx, edx, ecx)
+	maxExtLeaf, _, _, _ := cpuid(0x80000000, 0)
+	if maxExtLeaf >= 0x80000004 {
+		var regs []uint32
+		for leaf := uint32(0x80000002); leaf <= 0x80000004; leaf++ {
+			a, b, c, d := cpuid(leaf, 0)
+			regs = append(regs, a, b, c, d)
+		}
+		i.Brand = registerString(regs...)
+	}
+	if maxLeaf < 1 {
+		return i
+	}
+	eax, ebx, ecx, edx := cpuid(1, 0)
+	i.Stepping = int(eax & 0xF)
+	i.Model = int(eax>>4) & 0xF
+	i.Family = int(eax>>8) & 0xF
+	if i.Family == 0xF {
+		i.Family += int(eax>>20) & 0xFF
+	}
+	if i.Family == 0x6 || i.Family >= 0xF {
+		i.Model += int(eax>>16) & 0xF << 4
+	}
+	// The CLFLUSH line size, in 8 byte units, used if leaf 4 is missing
+	clflushBytes := int(ebx>>8) & 0xFF * 8
+	// Bit 28 of EDX (HTT) only tells us the package may have several
+	// logical processors, not that cores are shared
+	htt := edx&(1<<28) != 0
+	var xcr0 uint64
+	if ecx&(1<<27) != 0 {
+		xcr0 = xgetbv()
+	}
+	// XMM and YMM state, then opmask, ZMM_Hi256 and Hi16_ZMM state
+	avxOS := xcr0&0x6 == 0x6
+	avx512OS := avxOS && xcr0&0xE0 == 0xE0
+	if maxLeaf >= 7 {
+		_, ebx, _, _ := cpuid(7, 0)
+		i.AVX2 = avxOS && ebx&(1<<5) != 0
+		i.BMI2 = ebx&(1<<8) != 0
+		i.AVX512F = avx512OS && ebx&(1<<16) != 0
+	}
+	if maxExtLeaf >= 0x80000001 {
+		_, _, _, edx := cpuid(0x80000001, 0)
+		i.RDTSCP = edx&(1<<27) != 0
+	}
+	if maxExtLeaf >= 0x80000007 {
+		_, _, _, edx := cpuid(0x80000007, 0)
+		i.InvariantTSC = edx&(1<<8) != 0
+	}
+	i.ConstantTSC = i.InvariantTSC || constantTSC(i.Vendor, i.Family, i.Model)
+	switch {
+	case i.Vendor == intel && maxLeaf >= 4:
+		i.Caches = x86Caches(cpuid, 4)
+	case i.Vendor == amd && maxExtLeaf >= 0x8000001D:
+		i.Caches = x86Caches(cpuid, 0x8000001D)
+	}
+	i.CacheLineBytes = clflushBytes
+	for _, c := range i.Caches {
+		if c.Level == 1 && c.Type != InstructionCache {
+			i.CacheLineBytes = c.LineBytes
+			break
+		}
+	}
+	switch {
+	case maxLeaf >= 0xB:
+		// Subleaf 0 of the extended topology leaf describes the SMT level
+		_, ebx, ecx, _ := cpuid(0xB, 0)
+		if (ecx>>8)&0xFF == 1 {
+			i.ThreadsPerCore = int(ebx & 0xFFFF)
+		}
+	case i.Vendor == amd && maxExtLeaf >= 0x8000001E:
+		_, ebx, _, _ := cpuid(0x8000001E, 0)
+		i.ThreadsPerCore = int(ebx>>8)&0xFF + 1
+	}
+	if i.ThreadsPerCore == 0 && !htt {
+		i.ThreadsPerCore = 1
+	}
+	i.HyperThreading = i.ThreadsPerCore > 1
+	return i
+}
+
+// Reads the deterministic cache parameters, leaf 4 on Intel and
+// 0x8000001D on AMD share the same layout. Each subleaf describes a
+// cache until one of type 0 is found.
+func x86Caches(cpuid cpuidFunc, leaf uint32) []Cache {
+	var caches []Cache
+	for subleaf := uint32(0); subleaf < 16; subleaf++ {
+		eax, ebx, ecx, _ := cpuid(leaf, subleaf)
+		var c Cache
+		switch eax & 0x1F {
+		case 1:
+			c.Type = DataCache
+		case 2:
+			c.Type = InstructionCache
+		case 3:
+			c.Type = UnifiedCache
+		default:
+			return caches
+		}
+		c.Level = int(eax>>5) & 0x7
+		c.SharedBy = int(eax>>14)&0xFFF + 1
+		c.LineBytes = int(ebx&0xFFF) + 1
+		partitions := int(ebx>>12)&0x3FF + 1
+		c.Ways = int(ebx>>22) + 1
+		c.Sets = int(ecx) + 1
+		c.Bytes = c.Ways * partitions * c.LineBytes * c.Sets
+		caches = append(caches, c)
+	}
+	return caches
+}
+
+// CPUs without an invariant TSC may still have a constant rate TSC,
+// there is no CPUID bit for this so we follow the Linux kernel.
+func constantTSC(vendor string, family, model int) bool {
+	switch vendor {
+	case intel:
+		return (family == 0x6 && model >= 0xE) || (family == 0xF && model >= 0x3)
+	case amd:
+		return family >= 0x10
+	}
+	return false
+}
+
+// Registers are read as little endian ASCII, trailing NULs and spaces
+// are removed.
+func registerString(regs ...uint32) string {
+	b := make([]byte, 4*len(regs))
+	for i, r := range regs {
+		binary.LittleEndian.PutUint32(b[4*i:], r)
+	}
+	return strings.TrimSpace(strings.TrimRight(string(b), "\x00"))
+}
@@ -0,0 +1,343 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+// Package flog is an asynchronous logger for hot code paths. Goroutines
+// log through their own Writer, which encodes each record in binary
+// form into a ByteMsgQ without allocating. A Logger reads the records
+// from every Writer in the background, formats them and writes them
+// out.
+//
+// A record carries the ftime.Counter() value at which it was logged, a
+// level, the id of a format registered with the Logger and a list of
+// typed arguments. The Logger converts the counter value to wall clock
+// time when the record is written out. If a Writer's queue is full the record is dropped
+// and counted, logging never blocks.
+package flog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fmstephe/flib/fsync/padded"
+	"github.com/fmstephe/flib/ftime"
+	"github.com/fmstephe/flib/queues/spscq"
+)
+
+// Levels share their values with log/slog
+type Level int8
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch {
+	case l < LevelInfo:
+		return "DEBUG"
+	case l < LevelWarn:
+		return "INFO"
+	case l < LevelError:
+		return "WARN"
+	}
+	return "ERROR"
+}
+
+// Identifies a format registered with a Logger
+type FormatID uint32
+
+// Format 0 is reserved for records logged by Handler
+const slogFormat FormatID = 0
+
+type argKind byte
+
+const (
+	kindInt argKind = iota
+	kindUint
+	kindFloat
+	kindBool
+	kindStr
+)
+
+// A typed argument to a log record. Args are passed by value, so
+// building them does not allocate.
+type Arg struct {
+	kind argKind
+	num  uint64
+	str  string
+}
+
+func Int(v int64) Arg {
+	return Arg{kind: kindInt, num: uint64(v)}
+}
+
+func Uint(v uint64) Arg {
+	return Arg{kind: kindUint, num: v}
+}
+
+func Float(v float64) Arg {
+	return Arg{kind: kindFloat, num: math.Float64bits(v)}
+}
+
+func Bool(v bool) Arg {
+	if v {
+		return Arg{kind: kindBool, num: 1}
+	}
+	return Arg{kind: kindBool}
+}
+
+func Str(v string) Arg {
+	return Arg{kind: kindStr, str: v}
+}
+
+func (a Arg) size() int64 {
+	if a.kind == kindStr {
+		return 1 + 4 + int64(len(a.str))
+	}
+	return 1 + 8
+}
+
+func (a Arg) value() interface{} {
+	switch a.kind {
+	case kindInt:
+		return int64(a.num)
+	case kindUint:
+		return a.num
+	case kindFloat:
+		return math.Float64frombits(a.num)
+	case kindBool:
+		return a.num != 0
+	}
+	return a.str
+}
+
+// Record layout
+// [0:8]   ftime.Counter()
+// [8]     level
+// [9:13]  format id
+// [13]    number of args
+// [14:]   args, each a kind byte followed by 8 bytes, or for strings
+// a kind byte, a 4 byte length and the string's bytes
+const recordHeader = 14
+
+// The most args a record holds, as the count is stored in a byte
+const MaxArgs = 255
+
+// A Writer logs records for a single goroutine. It must not be shared
+// between goroutines.
+type Writer struct {
+	q       *spscq.ByteMsgQ
+	dropped padded.Int64
+}
+
+// Logs a record. If the Writer's queue is full the record is dropped.
+// Args beyond the first MaxArgs are discarded.
+func (w *Writer) Log(level Level, format FormatID, args ...Arg) {
+	if len(args) > MaxArgs {
+		args = args[:MaxArgs]
+	}
+	size := int64(recordHeader)
+	for i := range args {
+		size += args[i].size()
+	}
+	msg := w.q.AcquireWrite(size)
+	if msg == nil {
+		atomic.AddInt64(&w.dropped.Value, 1)
+		return
+	}
+	binary.LittleEndian.PutUint64(msg, uint64(ftime.Counter()))
+	msg[8] = byte(level)
+	binary.LittleEndian.PutUint32(msg[9:], uint32(format))
+	msg[13] = byte(len(args))
+	i := recordHeader
+	for _, arg := range args {
+		msg[i] = byte(arg.kind)
+		i++
+		if arg.kind == kindStr {
+			binary.LittleEndian.PutUint32(msg[i:], uint32(len(arg.str)))
+			i += 4
+			i += copy(msg[i:], arg.str)
+		} else {
+			binary.LittleEndian.PutUint64(msg[i:], arg.num)
+			i += 8
+		}
+	}
+	w.q.ReleaseWrite()
+}
+
+// The number of records dropped because the queue was full. Safe to
+// call from any goroutine.
+func (w *Writer) Dropped() int64 {
+	return atomic.LoadInt64(&w.dropped.Value)
+}
+
+// A Logger formats the records logged by its Writers and writes them
+// to out, one line per record.
+type Logger struct {
+	out     io.Writer
+	mu      sync.Mutex
+	formats []string
+	writers []*Writer
+	stop    chan bool
+	done    chan bool
+	// The wall clock time at baseTicks, used to timestamp records
+	baseTime  time.Time
+	baseTicks int64
+	// Used only while formatting
+	line   []byte
+	args   []Arg
+	values []interface{}
+}
+
+func NewLogger(out io.Writer) *Logger {
+	// Format 0 is reserved for Handler
+	l := &Logger{out: out, formats: []string{""}}
+	l.setBase()
+	return l
+}
+
+func (l *Logger) setBase() {
+	l.baseTime = time.Now()
+	l.baseTicks = ftime.Counter()
+}
+
+// Registers a fmt style format. Formats are usually registered once at
+// start up and the returned FormatID used for every record logged.
+func (l *Logger) Format(format string) FormatID {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.formats = append(l.formats, format)
+	return FormatID(len(l.formats) - 1)
+}
+
+// Creates a new Writer, for use by a single goroutine, whose queue holds
+// size bytes. Size must be a power of two.
+func (l *Logger) NewWriter(size int64) (*Writer, error) {
+	q, err := spscq.NewByteMsgQ(size, 0)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{q: q}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.writers = append(l.writers, w)
+	return w, nil
+}
+
+// The number of records dropped by all Writers.
+func (l *Logger) Dropped() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	dropped := int64(0)
+	for _, w := range l.writers {
+		dropped += w.Dropped()
+	}
+	return dropped
+}
+
+// Starts a goroutine which writes out logged records. When no records
+// are found it sleeps for interval. Record timestamps are measured from
+// the wall clock time when Start is called, or NewLogger if it is not.
+func (l *Logger) Start(interval time.Duration) {
+	l.setBase()
+	l.stop = make(chan bool)
+	l.done = make(chan bool)
+	go func() {
+		defer close(l.done)
+		for {
+			select {
+			case <-l.stop:
+				l.Flush()
+				return
+			default:
+			}
+			if l.Flush() == 0 {
+				time.Sleep(interval)
+			}
+		}
+	}()
+}
+
+// Stops the goroutine started by Start, after writing out all records
+// logged before Close was called. Does nothing if Start was not called.
+func (l *Logger) Close() {
+	if l.stop == nil {
+		return
+	}
+	close(l.stop)
+	<-l.done
+}
+
+// Writes out every available record. Returns the number of records
+// written. Must not be called concurrently with itself, or while the
+// Logger is started.
+func (l *Logger) Flush() int64 {
+	l.mu.Lock()
+	formats := l.formats
+	writers := l.writers
+	l.mu.Unlock()
+	count := int64(0)
+	for _, w := range writers {
+		count += w.q.Drain(func(msg []byte) {
+			l.writeRecord(formats, msg)
+		})
+	}
+	return count
+}
+
+func (l *Logger) writeRecord(formats []string, msg []byte) {
+	ticks := int64(binary.LittleEndian.Uint64(msg))
+	level := Level(int8(msg[8]))
+	format := FormatID(binary.LittleEndian.Uint32(msg[9:]))
+	l.args = decodeArgs(l.args[:0], msg)
+	line := l.line[:0]
+	line = l.timestamp(ticks).AppendFormat(line, time.RFC3339Nano)
+	line = fmt.Appendf(line, " %s ", level)
+	if format == slogFormat {
+		line = appendSlog(line, l.args)
+	} else if int(format) < len(formats) {
+		l.values = l.values[:0]
+		for _, arg := range l.args {
+			l.values = append(l.values, arg.value())
+		}
+		line = fmt.Appendf(line, formats[format], l.values...)
+	} else {
+		line = fmt.Appendf(line, "unknown format %d %v", format, l.args)
+	}
+	line = append(line, '\n')
+	l.out.Write(line)
+	l.line = line
+}
+
+// Converts a ftime.Counter() value to wall clock time
+func (l *Logger) timestamp(ticks int64) time.Time {
+	return l.baseTime.Add(ftime.TicksToDuration(ticks - l.baseTicks))
+}
+
+func decodeArgs(args []Arg, msg []byte) []Arg {
+	count := int(msg[13])
+	i := recordHeader
+	for n := 0; n < count; n++ {
+		arg := Arg{kind: argKind(msg[i])}
+		i++
+		if arg.kind == kindStr {
+			size := int(binary.LittleEndian.Uint32(msg[i:]))
+			i += 4
+			arg.str = string(msg[i : i+size])
+			i += size
+		} else {
+			arg.num = binary.LittleEndian.Uint64(msg[i:])
+			i += 8
+		}
+		args = append(args, arg)
+	}
+	return args
+}
@@ -0,0 +1,138 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package flog
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+)
+
+// A log/slog Handler which logs through a Writer. Because a Writer may
+// only be used by one goroutine the Handler serialises calls to Handle,
+// it is safe for concurrent use but is not as cheap as using a Writer
+// directly.
+//
+// Records are written as the message followed by key=value pairs.
+// Attribute values other than strings, integers, floats and bools are
+// converted to strings when they are logged.
+type Handler struct {
+	shared *handlerWriter
+	level  slog.Leveler
+	prefix string
+	attrs  []Arg
+}
+
+type handlerWriter struct {
+	mu   sync.Mutex
+	w    *Writer
+	args []Arg
+}
+
+// Creates a Handler with its own Writer, whose queue holds size bytes.
+// Records below level are discarded.
+func NewHandler(l *Logger, size int64, level slog.Leveler) (*Handler, error) {
+	w, err := l.NewWriter(size)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{shared: &handlerWriter{w: w}, level: level}, nil
+}
+
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *Handler) Handle(_ context.Context, r slog.Record) error {
+	s := h.shared
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.args = append(s.args[:0], Str(r.Message))
+	s.args = append(s.args, h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		s.args = appendAttr(s.args, h.prefix, a)
+		return true
+	})
+	s.w.Log(slogLevel(r.Level), slogFormat, s.args...)
+	return nil
+}
+
+// Clamps a slog.Level, which is an int, into a Level
+func slogLevel(level slog.Level) Level {
+	if level < math.MinInt8 {
+		return math.MinInt8
+	}
+	if level > math.MaxInt8 {
+		return math.MaxInt8
+	}
+	return Level(level)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append([]Arg(nil), h.attrs...)
+	for _, a := range attrs {
+		h2.attrs = appendAttr(h2.attrs, h.prefix, a)
+	}
+	return &h2
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix = h.prefix + name + "."
+	return &h2
+}
+
+func appendAttr(args []Arg, prefix string, a slog.Attr) []Arg {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix = prefix + a.Key + "."
+		}
+		for _, ga := range v.Group() {
+			args = appendAttr(args, prefix, ga)
+		}
+		return args
+	}
+	if a.Key == "" {
+		return args
+	}
+	key := a.Key
+	if prefix != "" {
+		key = prefix + a.Key
+	}
+	args = append(args, Str(key))
+	switch v.Kind() {
+	case slog.KindInt64:
+		return append(args, Int(v.Int64()))
+	case slog.KindUint64:
+		return append(args, Uint(v.Uint64()))
+	case slog.KindFloat64:
+		return append(args, Float(v.Float64()))
+	case slog.KindBool:
+		return append(args, Bool(v.Bool()))
+	case slog.KindString:
+		return append(args, Str(v.String()))
+	}
+	return append(args, Str(v.String()))
+}
+
+// Formats a record logged by Handler, the message followed by
+// key=value pairs.
+func appendSlog(line []byte, args []Arg) []byte {
+	if len(args) == 0 {
+		return line
+	}
+	line = append(line, args[0].str...)
+	for i := 1; i+1 < len(args); i += 2 {
+		line = fmt.Appendf(line, " %s=%v", args[i].str, args[i+1].value())
+	}
+	return line
+}
@@ -0,0 +1,258 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package flog
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Strips the leading timestamp from each line
+func lines(t *testing.T, out *bytes.Buffer) []string {
+	var result []string
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			t.Fatalf("Malformed line %q", line)
+		}
+		result = append(result, line[i+1:])
+	}
+	return result
+}
+
+func checkLines(t *testing.T, expected, found []string) {
+	if len(expected) != len(found) {
+		t.Fatalf("Expected %d lines %q, found %d %q", len(expected), expected, len(found), found)
+	}
+	for i := range expected {
+		if expected[i] != found[i] {
+			t.Errorf("Expected line %d to be %q, found %q", i, expected[i], found[i])
+		}
+	}
+}
+
+func TestLogFlush(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := NewLogger(out)
+	order := l.Format("order %d price %.2f side %s filled %t")
+	cancel := l.Format("cancel %d")
+	w, err := l.NewWriter(1024)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	w.Log(LevelInfo, order, Int(7), Float(10.5), Str("buy"), Bool(true))
+	w.Log(LevelWarn, cancel, Uint(8))
+	if count := l.Flush(); count != 2 {
+		t.Errorf("Expected to flush 2 records, flushed %d", count)
+	}
+	checkLines(t, []string{
+		"INFO order 7 price 10.50 side buy filled true",
+		"WARN cancel 8",
+	}, lines(t, out))
+	if count := l.Flush(); count != 0 {
+		t.Errorf("Expected to flush 0 records, flushed %d", count)
+	}
+}
+
+// Records are timestamped with the wall clock time they were logged at
+func TestLogTimestamp(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := NewLogger(out)
+	w, err := l.NewWriter(1024)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	before := time.Now()
+	w.Log(LevelInfo, l.Format("timed"))
+	after := time.Now()
+	l.Flush()
+	line := strings.TrimSuffix(out.String(), "\n")
+	stamp, err := time.Parse(time.RFC3339Nano, line[:strings.IndexByte(line, ' ')])
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// Allows for error in the counter's calibration
+	slack := 10 * time.Millisecond
+	if stamp.Before(before.Add(-slack)) || stamp.After(after.Add(slack)) {
+		t.Errorf("Expected timestamp between %s and %s, found %s", before, after, stamp)
+	}
+}
+
+func TestLogDropped(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := NewLogger(out)
+	format := l.Format("value %d")
+	w, err := l.NewWriter(64)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// Each record is 8 bytes of queue header, 14 bytes of record header
+	// and 9 bytes of argument, so only two fit in the queue
+	for i := 0; i < 5; i++ {
+		w.Log(LevelInfo, format, Int(int64(i)))
+	}
+	if w.Dropped() != 3 {
+		t.Errorf("Expected 3 dropped records, found %d", w.Dropped())
+	}
+	if l.Dropped() != 3 {
+		t.Errorf("Expected 3 dropped records for logger, found %d", l.Dropped())
+	}
+	l.Flush()
+	checkLines(t, []string{"INFO value 0", "INFO value 1"}, lines(t, out))
+}
+
+func TestLogDoesNotAllocate(t *testing.T) {
+	l := NewLogger(&bytes.Buffer{})
+	format := l.Format("%d %f %s %t")
+	w, err := l.NewWriter(1024)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// Records are read directly, rather than flushed, because
+	// formatting allocates
+	allocs := testing.AllocsPerRun(100, func() {
+		w.Log(LevelInfo, format, Int(1), Float(2), Str("three"), Bool(true))
+		w.q.AcquireRead()
+		w.q.ReleaseRead()
+	})
+	if allocs != 0 {
+		t.Errorf("Expected Log not to allocate, found %f allocations", allocs)
+	}
+}
+
+func TestLogUnknownFormat(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := NewLogger(out)
+	w, err := l.NewWriter(1024)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	w.Log(LevelError, 99)
+	l.Flush()
+	checkLines(t, []string{"ERROR unknown format 99 []"}, lines(t, out))
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func TestStartClose(t *testing.T) {
+	out := &syncBuffer{}
+	l := NewLogger(out)
+	format := l.Format("writer %d record %d")
+	const writers, records = 4, 100
+	var wg sync.WaitGroup
+	l.Start(time.Millisecond)
+	for i := 0; i < writers; i++ {
+		w, err := l.NewWriter(64 * 1024)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		wg.Add(1)
+		go func(i int64) {
+			defer wg.Done()
+			for r := int64(0); r < records; r++ {
+				w.Log(LevelDebug, format, Int(i), Int(r))
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+	l.Close()
+	if l.Dropped() != 0 {
+		t.Fatalf("Expected no dropped records, found %d", l.Dropped())
+	}
+	found := lines(t, &out.buf)
+	if len(found) != writers*records {
+		t.Fatalf("Expected %d lines, found %d", writers*records, len(found))
+	}
+	for _, line := range found {
+		if !strings.HasPrefix(line, "DEBUG writer ") {
+			t.Errorf("Unexpected line %q", line)
+		}
+	}
+}
+
+func TestCloseWithoutStart(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := NewLogger(out)
+	l.Close()
+	if out.Len() != 0 {
+		t.Errorf("Expected no output, found %q", out.String())
+	}
+}
+
+func TestHandler(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := NewLogger(out)
+	h, err := NewHandler(l, 1024, slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	logger := slog.New(h)
+	logger.Debug("hidden")
+	logger.Info("order", "id", 7, "price", 10.5, "side", "buy", "filled", true, "qty", uint64(3))
+	logger.With("venue", "x").WithGroup("g").Warn("cancel", "id", 8, slog.Group("by", "user", "fs"))
+	logger.Error("failed", "after", time.Second)
+	l.Flush()
+	checkLines(t, []string{
+		"INFO order id=7 price=10.5 side=buy filled=true qty=3",
+		"WARN cancel venue=x g.id=8 g.by.user=fs",
+		"ERROR failed after=1s",
+	}, lines(t, out))
+}
+
+// slog levels outside the range of Level are clamped, not truncated
+func TestHandlerLevelClamped(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := NewLogger(out)
+	h, err := NewHandler(l, 1024, slog.Level(-1000))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	logger := slog.New(h)
+	logger.Log(context.Background(), slog.Level(1000), "high")
+	logger.Log(context.Background(), slog.Level(-1000), "low")
+	l.Flush()
+	checkLines(t, []string{
+		"ERROR high",
+		"DEBUG low",
+	}, lines(t, out))
+}
+
+// Attributes beyond those which fit in MaxArgs are discarded
+func TestHandlerManyAttrs(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := NewLogger(out)
+	h, err := NewHandler(l, 8192, slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var attrs []any
+	expected := "INFO many"
+	for i := 0; i < 200; i++ {
+		attrs = append(attrs, fmt.Sprintf("k%d", i), i)
+		// The message and 127 key/value pairs fit
+		if i < (MaxArgs-1)/2 {
+			expected += fmt.Sprintf(" k%d=%d", i, i)
+		}
+	}
+	slog.New(h).Info("many", attrs...)
+	slog.New(h).Info("after")
+	l.Flush()
+	checkLines(t, []string{expected, "INFO after"}, lines(t, out))
+}
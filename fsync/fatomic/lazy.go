@@ -2,13 +2,38 @@
 // Use of this source code is governed by a BSD
 // license which can be found in LICENSE.txt
 
-// +build go1.6
-// +build amd64
+//go:build go1.6 && amd64 && !race && !purego
+// +build go1.6,amd64,!race,!purego
 
 package fatomic
 
+import "unsafe"
+
 //go:nosplit
 //go:noinline
 func LazyStore(addr *int64, val int64) {
 	*addr = val
 }
+
+//go:nosplit
+//go:noinline
+func LazyStoreUint64(addr *uint64, val uint64) {
+	*addr = val
+}
+
+//go:nosplit
+//go:noinline
+func LazyStoreInt32(addr *int32, val int32) {
+	*addr = val
+}
+
+//go:nosplit
+//go:noinline
+func LazyStoreUint32(addr *uint32, val uint32) {
+	*addr = val
+}
+
+//go:noinline
+func LazyStorePointer(addr *unsafe.Pointer, val unsafe.Pointer) {
+	*addr = val
+}
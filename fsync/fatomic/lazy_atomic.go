@@ -0,0 +1,41 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+//go:build race || !amd64 || purego
+// +build race !amd64 purego
+
+package fatomic
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// Only on amd64 does a plain store have release semantics. Elsewhere,
+// and when built with the purego tag, we use a real atomic store. On
+// arm64 this is a store-release (STLR) which is as cheap as a lazy
+// store can be.
+//
+// The race detector cannot see that a plain store on amd64 has release
+// semantics either. When running with -race we use a real atomic store
+// so that readers of lazily stored values are not reported as racing.
+func LazyStore(addr *int64, val int64) {
+	atomic.StoreInt64(addr, val)
+}
+
+func LazyStoreUint64(addr *uint64, val uint64) {
+	atomic.StoreUint64(addr, val)
+}
+
+func LazyStoreInt32(addr *int32, val int32) {
+	atomic.StoreInt32(addr, val)
+}
+
+func LazyStoreUint32(addr *uint32, val uint32) {
+	atomic.StoreUint32(addr, val)
+}
+
+func LazyStorePointer(addr *unsafe.Pointer, val unsafe.Pointer) {
+	atomic.StorePointer(addr, val)
+}
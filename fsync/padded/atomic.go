@@ -0,0 +1,138 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package padded
+
+import (
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/fmstephe/flib/fsync/fatomic"
+)
+
+// The atomic types below are laid out like Int64, each value alone on
+// its own cache line. Their values may only be accessed through their
+// methods, the zero value of each is ready to use. LazyStore is a
+// release store, see fatomic.LazyStore.
+
+type Uint64 struct {
+	// Aligns v to 8 bytes, even on 32 bit architectures
+	_      [0]atomic.Uint64
+	before [CacheLineBytes - 8]byte
+	v      uint64
+	after  [CacheLineBytes]byte
+}
+
+func (p *Uint64) Load() uint64 {
+	return atomic.LoadUint64(&p.v)
+}
+
+func (p *Uint64) Store(val uint64) {
+	atomic.StoreUint64(&p.v, val)
+}
+
+func (p *Uint64) LazyStore(val uint64) {
+	fatomic.LazyStoreUint64(&p.v, val)
+}
+
+func (p *Uint64) Add(delta uint64) (new uint64) {
+	return atomic.AddUint64(&p.v, delta)
+}
+
+func (p *Uint64) Swap(new uint64) (old uint64) {
+	return atomic.SwapUint64(&p.v, new)
+}
+
+func (p *Uint64) CompareAndSwap(old, new uint64) bool {
+	return atomic.CompareAndSwapUint64(&p.v, old, new)
+}
+
+type Int32 struct {
+	before [CacheLineBytes - 4]byte
+	v      int32
+	after  [CacheLineBytes]byte
+}
+
+func (p *Int32) Load() int32 {
+	return atomic.LoadInt32(&p.v)
+}
+
+func (p *Int32) Store(val int32) {
+	atomic.StoreInt32(&p.v, val)
+}
+
+func (p *Int32) LazyStore(val int32) {
+	fatomic.LazyStoreInt32(&p.v, val)
+}
+
+func (p *Int32) Add(delta int32) (new int32) {
+	return atomic.AddInt32(&p.v, delta)
+}
+
+func (p *Int32) Swap(new int32) (old int32) {
+	return atomic.SwapInt32(&p.v, new)
+}
+
+func (p *Int32) CompareAndSwap(old, new int32) bool {
+	return atomic.CompareAndSwapInt32(&p.v, old, new)
+}
+
+type Bool struct {
+	before [CacheLineBytes - 4]byte
+	v      uint32
+	after  [CacheLineBytes]byte
+}
+
+func b32(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (p *Bool) Load() bool {
+	return atomic.LoadUint32(&p.v) != 0
+}
+
+func (p *Bool) Store(val bool) {
+	atomic.StoreUint32(&p.v, b32(val))
+}
+
+func (p *Bool) LazyStore(val bool) {
+	fatomic.LazyStoreUint32(&p.v, b32(val))
+}
+
+func (p *Bool) Swap(new bool) (old bool) {
+	return atomic.SwapUint32(&p.v, b32(new)) != 0
+}
+
+func (p *Bool) CompareAndSwap(old, new bool) bool {
+	return atomic.CompareAndSwapUint32(&p.v, b32(old), b32(new))
+}
+
+type Pointer[T any] struct {
+	before [CacheLineBytes - unsafe.Sizeof(uintptr(0))]byte
+	v      unsafe.Pointer
+	after  [CacheLineBytes]byte
+}
+
+func (p *Pointer[T]) Load() *T {
+	return (*T)(atomic.LoadPointer(&p.v))
+}
+
+func (p *Pointer[T]) Store(val *T) {
+	atomic.StorePointer(&p.v, unsafe.Pointer(val))
+}
+
+func (p *Pointer[T]) LazyStore(val *T) {
+	fatomic.LazyStorePointer(&p.v, unsafe.Pointer(val))
+}
+
+func (p *Pointer[T]) Swap(new *T) (old *T) {
+	return (*T)(atomic.SwapPointer(&p.v, unsafe.Pointer(new)))
+}
+
+func (p *Pointer[T]) CompareAndSwap(old, new *T) bool {
+	return atomic.CompareAndSwapPointer(&p.v, unsafe.Pointer(old), unsafe.Pointer(new))
+}
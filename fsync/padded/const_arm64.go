@@ -0,0 +1,9 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package padded
+
+// Most arm64 cores use 64 byte cache lines, but some (e.g. Apple M
+// series) use 128 bytes. We pad for the larger.
+const CacheLineBytes = 128
@@ -0,0 +1,12 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+//go:build !amd64 && !arm64
+// +build !amd64,!arm64
+
+package padded
+
+// A conservative guess, large enough for the cache lines of most
+// architectures Go supports.
+const CacheLineBytes = 128
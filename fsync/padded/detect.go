@@ -0,0 +1,20 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package padded
+
+import (
+	"github.com/fmstephe/flib/fcpu"
+)
+
+// Returns the cache line size reported by the CPU, or CacheLineBytes if
+// it isn't reported. The padding in this package must be sized by a
+// constant, so CacheLineBytes is a safe upper bound for the
+// architecture rather than the real cache line size.
+func CacheLineSize() int {
+	if size := fcpu.CacheLineBytes(); size > 0 {
+		return size
+	}
+	return CacheLineBytes
+}
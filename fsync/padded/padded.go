@@ -0,0 +1,15 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package padded
+
+// Padded places Value alone on its own cache line, or lines if T is
+// larger than a cache line. A full cache line of padding lies on either
+// side of Value, so no other variable can share its cache lines
+// wherever the Padded is allocated.
+type Padded[T any] struct {
+	before [CacheLineBytes]byte
+	Value  T
+	after  [CacheLineBytes]byte
+}
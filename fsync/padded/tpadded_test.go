@@ -0,0 +1,182 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package padded
+
+import (
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+// A value of size bytes, naturally aligned, at offset within a struct of
+// total bytes, can't share a cache line with anything outside the
+// struct if there are at least CacheLineBytes-size bytes on either side.
+func checkPadding(t *testing.T, name string, offset, size, total uintptr) {
+	t.Helper()
+	before := offset
+	after := total - offset - size
+	if before < CacheLineBytes-size || after < CacheLineBytes-size {
+		t.Errorf("%s: %d bytes before and %d after a %d byte value, expected at least %d", name, before, after, size, CacheLineBytes-size)
+	}
+}
+
+func TestLayout(t *testing.T) {
+	var i64 Int64
+	checkPadding(t, "Int64", unsafe.Offsetof(i64.Value), unsafe.Sizeof(i64.Value), unsafe.Sizeof(i64))
+	var u64 Uint64
+	checkPadding(t, "Uint64", unsafe.Offsetof(u64.v), unsafe.Sizeof(u64.v), unsafe.Sizeof(u64))
+	var i32 Int32
+	checkPadding(t, "Int32", unsafe.Offsetof(i32.v), unsafe.Sizeof(i32.v), unsafe.Sizeof(i32))
+	var b Bool
+	checkPadding(t, "Bool", unsafe.Offsetof(b.v), unsafe.Sizeof(b.v), unsafe.Sizeof(b))
+	var p Pointer[int]
+	checkPadding(t, "Pointer", unsafe.Offsetof(p.v), unsafe.Sizeof(p.v), unsafe.Sizeof(p))
+	// 64 bit atomics must be 8 byte aligned, even on 32 bit architectures
+	if unsafe.Alignof(u64) != 8 || unsafe.Offsetof(u64.v)%8 != 0 {
+		t.Errorf("Uint64 is %d byte aligned, with its value at %d", unsafe.Alignof(u64), unsafe.Offsetof(u64.v))
+	}
+}
+
+func TestPaddedLayout(t *testing.T) {
+	// T may be larger than a cache line, or oddly sized
+	var small Padded[byte]
+	var large Padded[[3 * CacheLineBytes]byte]
+	var odd Padded[struct {
+		a int64
+		b byte
+	}]
+	for _, p := range []struct {
+		name                string
+		offset, size, total uintptr
+	}{
+		{"byte", unsafe.Offsetof(small.Value), unsafe.Sizeof(small.Value), unsafe.Sizeof(small)},
+		{"large", unsafe.Offsetof(large.Value), unsafe.Sizeof(large.Value), unsafe.Sizeof(large)},
+		{"odd", unsafe.Offsetof(odd.Value), unsafe.Sizeof(odd.Value), unsafe.Sizeof(odd)},
+	} {
+		before := p.offset
+		after := p.total - p.offset - p.size
+		if before < CacheLineBytes || after < CacheLineBytes {
+			t.Errorf("Padded[%s]: %d bytes before and %d after, expected at least %d", p.name, before, after, CacheLineBytes)
+		}
+	}
+}
+
+// Neighbouring values in an array are at least a cache line apart
+func TestArraySpacing(t *testing.T) {
+	var values [2]Uint64
+	distance := uintptr(unsafe.Pointer(&values[1].v)) - uintptr(unsafe.Pointer(&values[0].v))
+	if distance < CacheLineBytes {
+		t.Errorf("Neighbouring values %d bytes apart", distance)
+	}
+	var padded [2]Padded[int32]
+	distance = uintptr(unsafe.Pointer(&padded[1].Value)) - uintptr(unsafe.Pointer(&padded[0].Value))
+	if distance < CacheLineBytes {
+		t.Errorf("Neighbouring Padded values %d bytes apart", distance)
+	}
+}
+
+func TestUint64(t *testing.T) {
+	var p Uint64
+	p.Store(10)
+	if p.Add(5) != 15 || p.Load() != 15 {
+		t.Errorf("Expected 15, found %d", p.Load())
+	}
+	if p.CompareAndSwap(10, 20) || !p.CompareAndSwap(15, 20) {
+		t.Errorf("Unexpected CompareAndSwap result")
+	}
+	if p.Swap(30) != 20 {
+		t.Errorf("Expected to swap out 20")
+	}
+	p.LazyStore(40)
+	if p.Load() != 40 {
+		t.Errorf("Expected 40, found %d", p.Load())
+	}
+}
+
+func TestInt32(t *testing.T) {
+	var p Int32
+	p.Store(-10)
+	if p.Add(5) != -5 || p.Load() != -5 {
+		t.Errorf("Expected -5, found %d", p.Load())
+	}
+	if p.CompareAndSwap(10, 20) || !p.CompareAndSwap(-5, 20) {
+		t.Errorf("Unexpected CompareAndSwap result")
+	}
+	if p.Swap(30) != 20 {
+		t.Errorf("Expected to swap out 20")
+	}
+	p.LazyStore(40)
+	if p.Load() != 40 {
+		t.Errorf("Expected 40, found %d", p.Load())
+	}
+}
+
+func TestBool(t *testing.T) {
+	var p Bool
+	if p.Load() {
+		t.Errorf("Expected zero value to be false")
+	}
+	p.Store(true)
+	if !p.Load() {
+		t.Errorf("Expected true")
+	}
+	if p.CompareAndSwap(false, true) || !p.CompareAndSwap(true, false) {
+		t.Errorf("Unexpected CompareAndSwap result")
+	}
+	if p.Swap(true) {
+		t.Errorf("Expected to swap out false")
+	}
+	p.LazyStore(false)
+	if p.Load() {
+		t.Errorf("Expected false")
+	}
+}
+
+func TestPointer(t *testing.T) {
+	var p Pointer[int]
+	if p.Load() != nil {
+		t.Errorf("Expected zero value to be nil")
+	}
+	a, b := 1, 2
+	p.Store(&a)
+	if p.Load() != &a {
+		t.Errorf("Expected to load &a")
+	}
+	if p.CompareAndSwap(&b, &b) || !p.CompareAndSwap(&a, &b) {
+		t.Errorf("Unexpected CompareAndSwap result")
+	}
+	if p.Swap(&a) != &b {
+		t.Errorf("Expected to swap out &b")
+	}
+	p.LazyStore(nil)
+	if p.Load() != nil {
+		t.Errorf("Expected nil")
+	}
+}
+
+func TestConcurrentAdd(t *testing.T) {
+	const goroutines, adds = 4, 10 * 1000
+	var counters [2]Uint64
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < adds; i++ {
+				counters[g%2].Add(1)
+			}
+		}(g)
+	}
+	wg.Wait()
+	if counters[0].Load() != goroutines/2*adds || counters[1].Load() != goroutines/2*adds {
+		t.Errorf("Expected %d adds to each counter, found %d and %d", goroutines/2*adds, counters[0].Load(), counters[1].Load())
+	}
+}
+
+func TestCacheLineSize(t *testing.T) {
+	if size := CacheLineSize(); size < 1 || size > CacheLineBytes {
+		t.Errorf("Detected cache line size %d, padding only covers %d", size, CacheLineBytes)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package ftime
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/fmstephe/flib/fsync/padded"
+)
+
+const DefaultCachedResolution = 100 * time.Microsecond
+
+// Every cached time is measured in nanoseconds on the monotonic clock
+// since epoch. Wall clock times are epoch plus this duration, so they
+// don't follow changes made to the system's wall clock after the
+// package is initialised.
+var epoch = time.Now()
+
+func nanotime() int64 {
+	return int64(time.Since(epoch))
+}
+
+// A CachedClock reads the time in a background goroutine, once every
+// resolution, so that reading the time is a single atomic load. The
+// time read may be up to resolution old, or older if the background
+// goroutine is not scheduled promptly.
+type CachedClock struct {
+	nanos      padded.Int64
+	resolution time.Duration
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+func NewCachedClock(resolution time.Duration) *CachedClock {
+	c := &CachedClock{
+		resolution: resolution,
+		stop:       make(chan struct{}),
+	}
+	c.nanos.Value = nanotime()
+	go c.run()
+	return c
+}
+
+func (c *CachedClock) run() {
+	ticker := time.NewTicker(c.resolution)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			atomic.StoreInt64(&c.nanos.Value, nanotime())
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// The cached time.
+func (c *CachedClock) Now() time.Time {
+	return epoch.Add(time.Duration(c.Nanotime()))
+}
+
+// The cached number of nanoseconds, on the monotonic clock, since the
+// package was initialised.
+func (c *CachedClock) Nanotime() int64 {
+	return atomic.LoadInt64(&c.nanos.Value)
+}
+
+func (c *CachedClock) Resolution() time.Duration {
+	return c.resolution
+}
+
+// Stops the background goroutine, the cached time no longer advances.
+func (c *CachedClock) Close() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+var (
+	defaultClockOnce sync.Once
+	defaultClock     *CachedClock
+)
+
+func cachedClock() *CachedClock {
+	defaultClockOnce.Do(func() {
+		defaultClock = NewCachedClock(DefaultCachedResolution)
+	})
+	return defaultClock
+}
+
+// The time, as cached by a shared CachedClock with
+// DefaultCachedResolution. The clock is started on first use.
+func CachedNow() time.Time {
+	return cachedClock().Now()
+}
+
+// As CachedNow(), the number of nanoseconds on the monotonic clock
+// since the package was initialised.
+func CachedNanotime() int64 {
+	return cachedClock().Nanotime()
+}
+
+// The point a CounterClock extrapolates from, replaced in full at each
+// resync so that readers always see a consistent set of values.
+type counterBase struct {
+	count int64
+	// The reading at count, which may be ahead of the monotonic clock
+	nanos int64
+	// The monotonic clock at count
+	synced int64
+	// The measured rate of Counter()
+	measured float64
+	// The rate used for extrapolation
+	ticksPerNano float64
+}
+
+// A CounterClock reads Counter() and converts the ticks elapsed since
+// its last resync into nanoseconds. A background goroutine resyncs with
+// the monotonic clock once every resync, measuring the rate of ticks per
+// nanosecond as it goes. Unlike CachedClock every reading advances, but
+// it may drift from the monotonic clock between resyncs.
+//
+// The clock never runs backwards. If it has run ahead of the monotonic
+// clock at a resync it is slowed, rather than stepped back, until the
+// monotonic clock catches up. Readings which would still be behind the
+// last reading, because the new rate is slower than the old, return the
+// last reading instead.
+type CounterClock struct {
+	base unsafe.Pointer // *counterBase
+	// The latest reading returned by Nanotime
+	last     padded.Int64
+	resync   time.Duration
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewCounterClock(resync time.Duration) *CounterClock {
+	c := &CounterClock{
+		resync: resync,
+		stop:   make(chan struct{}),
+	}
+	nanos := nanotime()
+	rate := TicksPerNanosecond()
+	atomic.StorePointer(&c.base, unsafe.Pointer(&counterBase{
+		count:        counter(),
+		nanos:        nanos,
+		synced:       nanos,
+		measured:     rate,
+		ticksPerNano: rate,
+	}))
+	go c.run()
+	return c
+}
+
+func (c *CounterClock) run() {
+	ticker := time.NewTicker(c.resync)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.resyncBase()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (c *CounterClock) resyncBase() {
+	old := (*counterBase)(atomic.LoadPointer(&c.base))
+	count := counter()
+	synced := nanotime()
+	next := &counterBase{
+		count:    count,
+		nanos:    synced,
+		synced:   synced,
+		measured: old.measured,
+	}
+	if elapsed := synced - old.synced; elapsed > 0 && count > old.count {
+		next.measured = float64(count-old.count) / float64(elapsed)
+	}
+	next.ticksPerNano = next.measured
+	// Readers of the old base may have seen times ahead of the monotonic
+	// clock, slow down so that it catches up by the next resync
+	if extrapolated := old.extrapolate(count); extrapolated > synced {
+		next.nanos = extrapolated
+		period := float64(c.resync)
+		ahead := math.Min(float64(extrapolated-synced), period/2)
+		next.ticksPerNano = next.measured * period / (period - ahead)
+	}
+	atomic.StorePointer(&c.base, unsafe.Pointer(next))
+}
+
+func (b *counterBase) extrapolate(count int64) int64 {
+	return b.nanos + int64(float64(count-b.count)/b.ticksPerNano)
+}
+
+// The extrapolated time.
+func (c *CounterClock) Now() time.Time {
+	return epoch.Add(time.Duration(c.Nanotime()))
+}
+
+// The extrapolated number of nanoseconds, on the monotonic clock, since
+// the package was initialised.
+func (c *CounterClock) Nanotime() int64 {
+	base := (*counterBase)(atomic.LoadPointer(&c.base))
+	nanos := base.extrapolate(counter())
+	for {
+		last := atomic.LoadInt64(&c.last.Value)
+		if nanos <= last {
+			return last
+		}
+		if atomic.CompareAndSwapInt64(&c.last.Value, last, nanos) {
+			return nanos
+		}
+	}
+}
+
+// Stops the background goroutine, the clock continues to extrapolate
+// from its last resync.
+func (c *CounterClock) Close() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
@@ -0,0 +1,90 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package ftime
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The default period over which Counter() is measured against the
+// monotonic clock, when the hardware doesn't report its frequency.
+const calibrationPeriod = 10 * time.Millisecond
+
+var (
+	calibrateOnce sync.Once
+	// math.Float64bits of the number of ticks per nanosecond
+	ticksPerNano uint64
+)
+
+// Returns the frequency, in ticks per second, of Counter() as reported
+// by the hardware.
+// On AMD64 CPUs we read CPUID leaf 0x15, or 0x16, which many CPUs and
+// most hypervisors don't report. ok is false if the frequency is not
+// reported, or if the TSC is not invariant.
+// On ARM64 CPUs we read CNTFRQ_EL0
+func CounterFrequency() (hz int64, ok bool) {
+	return counterFrequency()
+}
+
+// Returns the number of Counter() ticks per nanosecond. On first use
+// this is taken from CounterFrequency(), or if that is unavailable
+// measured by Calibrate().
+func TicksPerNanosecond() float64 {
+	calibrateOnce.Do(func() {
+		if hz, ok := counterFrequency(); ok {
+			setTicksPerNano(float64(hz) / float64(time.Second))
+			return
+		}
+		setTicksPerNano(measure(calibrationPeriod))
+	})
+	return math.Float64frombits(atomic.LoadUint64(&ticksPerNano))
+}
+
+// Measures the number of Counter() ticks per nanosecond against the
+// monotonic clock over period. The result is used by every conversion
+// from then on, and returned. Longer periods give more accurate
+// results. This blocks for period.
+func Calibrate(period time.Duration) float64 {
+	rate := measure(period)
+	calibrateOnce.Do(func() {})
+	setTicksPerNano(rate)
+	return rate
+}
+
+func setTicksPerNano(rate float64) {
+	atomic.StoreUint64(&ticksPerNano, math.Float64bits(rate))
+}
+
+// Spins for period, rather than sleeping, so the goroutine is not
+// descheduled between the clock and the counter reads.
+func measure(period time.Duration) float64 {
+	startTime := time.Now()
+	startCount := counter()
+	for time.Since(startTime) < period {
+	}
+	elapsed := time.Since(startTime)
+	count := counter() - startCount
+	return float64(count) / float64(elapsed)
+}
+
+// Converts a number of Counter() ticks into a time.Duration
+func TicksToDuration(ticks int64) time.Duration {
+	return time.Duration(float64(ticks) / TicksPerNanosecond())
+}
+
+// Converts a time.Duration into a number of Counter() ticks. The
+// result is useful as the pause argument to Pause() or the queues.
+func DurationToTicks(d time.Duration) int64 {
+	return int64(float64(d) * TicksPerNanosecond())
+}
+
+// This method will not return until at least d has passed, as measured
+// by Counter(). See Pause().
+func PauseDuration(d time.Duration) {
+	pause(DurationToTicks(d))
+}
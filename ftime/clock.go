@@ -0,0 +1,115 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package ftime
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// A Clock provides the counter, pausing and wall clock time used by
+// code which would otherwise call this package directly. Code which
+// accepts a Clock can be tested deterministically with a FakeClock.
+type Clock interface {
+	// As Counter()
+	Counter() int64
+	// As Pause(), returns once Counter() has increased by ticks
+	Pause(ticks int64)
+	// As time.Sleep()
+	Sleep(d time.Duration)
+	// As time.Now()
+	Now() time.Time
+	// The rate at which Counter() ticks, as TicksPerNanosecond()
+	TicksPerNanosecond() float64
+}
+
+// RealClock is the Clock provided by this package and the time package.
+type RealClock struct{}
+
+func (RealClock) Counter() int64 {
+	return counter()
+}
+
+func (RealClock) Pause(ticks int64) {
+	pause(ticks)
+}
+
+func (RealClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) TicksPerNanosecond() float64 {
+	return TicksPerNanosecond()
+}
+
+// A FakeClock only moves when it is advanced, or when something pauses
+// or sleeps on it, which advances it by the time paused. Pauses and
+// sleeps are counted, so that backoff can be tested. Safe for
+// concurrent use.
+type FakeClock struct {
+	ticks        int64
+	pauses       int64
+	paused       int64
+	start        time.Time
+	ticksPerNano float64
+}
+
+// Creates a FakeClock whose Now() is start, and whose Counter() is 0,
+// ticking ticksPerNano times a nanosecond.
+func NewFakeClock(start time.Time, ticksPerNano float64) *FakeClock {
+	return &FakeClock{start: start, ticksPerNano: ticksPerNano}
+}
+
+func (c *FakeClock) Counter() int64 {
+	return atomic.LoadInt64(&c.ticks)
+}
+
+// Advances the clock by ticks.
+func (c *FakeClock) Pause(ticks int64) {
+	atomic.AddInt64(&c.pauses, 1)
+	if ticks > 0 {
+		atomic.AddInt64(&c.paused, ticks)
+		c.Advance(ticks)
+	}
+}
+
+// Advances the clock by d.
+func (c *FakeClock) Sleep(d time.Duration) {
+	c.Pause(c.durationToTicks(d))
+}
+
+func (c *FakeClock) Now() time.Time {
+	return c.start.Add(time.Duration(float64(c.Counter()) / c.ticksPerNano))
+}
+
+func (c *FakeClock) TicksPerNanosecond() float64 {
+	return c.ticksPerNano
+}
+
+func (c *FakeClock) Advance(ticks int64) {
+	atomic.AddInt64(&c.ticks, ticks)
+}
+
+func (c *FakeClock) AdvanceDuration(d time.Duration) {
+	c.Advance(c.durationToTicks(d))
+}
+
+// The number of calls to Pause() and Sleep().
+func (c *FakeClock) Pauses() int64 {
+	return atomic.LoadInt64(&c.pauses)
+}
+
+// The total ticks paused and slept.
+func (c *FakeClock) Paused() int64 {
+	return atomic.LoadInt64(&c.paused)
+}
+
+func (c *FakeClock) durationToTicks(d time.Duration) int64 {
+	return int64(float64(d) * c.ticksPerNano)
+}
@@ -8,32 +8,69 @@ package ftime
 // counter your cpu can provide. Guaranteed to increase monotonically
 // across successive calls on the same CPU core.
 // On AMD64 CPUs we use the RDTSC instruction
-func Counter() (count int64)
+// On ARM64 CPUs we read the virtual counter CNTVCT_EL0
+// On other CPUs we fall back to the runtime's monotonic clock, counting
+// nanoseconds
+func Counter() (count int64) {
+	return counter()
+}
+
+// Reads the same counter as Counter(), but not until every preceding
+// instruction has completed. Use at the start of a timed section of
+// code, so the reading isn't taken early.
+// On AMD64 CPUs we use LFENCE;RDTSC
+func CounterStart() (count int64) {
+	return counterStart()
+}
+
+// Reads the same counter as Counter(), after every preceding
+// instruction has completed and before any following instruction
+// begins. Use at the end of a timed section of code, so the reading is
+// neither taken early nor delayed by what follows.
+// On AMD64 CPUs we use RDTSCP;LFENCE, or LFENCE;RDTSC;LFENCE on CPUs
+// without RDTSCP
+func CounterEnd() (count int64) {
+	return counterEnd()
+}
+
+// As CounterEnd(), also returning the ID of the processor the counter
+// was read on. Compare it against an earlier reading to detect that the
+// goroutine has moved to another core, making the elapsed ticks
+// unreliable unless IsCounterSMPMonotonic().
+// On AMD64 CPUs this is IA32_TSC_AUX as read by RDTSCP, on Linux the CPU
+// number in the low 12 bits and NUMA node above them. Where no ID is
+// available processor is -1
+func CounterEndProcessor() (count int64, processor int64) {
+	return counterEndProcessor()
+}
 
 // Indicates whether the results returned from a call to Counter()
 // increase at a uniform rate, independent of the actual clock speed
 // of the CPU it is running on.
-// On AMD64 CPUs we test for the 'Invariant TSC' property using CPUID
+// On AMD64 CPUs we test for the 'Invariant TSC' property, see fcpu
+// The ARM64 generic timer, and the monotonic clock, are always steady
 func IsCounterSteady() bool {
-	_, _, _, edx := cpuid(0X80000007)
-	return edx&(1<<8) != 0
+	return counterSteady()
 }
 
 // Indicates whether the results returned from a call to Counter()
 // is guaranteed to be monotonically increasing per CPU and across
 // multiple CPUs on the same socket. No guarantee is made across CPU
 // sockets
-// On AMD64 CPUs we test for the 'Invariant TSC' property using CPUID
+// On AMD64 CPUs we test for the 'Invariant TSC' property, see fcpu
+// The ARM64 system counter, and the monotonic clock, are shared by
+// every CPU
 func IsCounterSMPMonotonic() bool {
-	_, _, _, edx := cpuid(0X80000007)
-	return edx&(1<<8) != 0
+	return counterSMPMonotonic()
 }
 
-func cpuid(eaxi uint32) (eax, ebx, ecx, edx uint32)
-
 // This method will not return until the value returned by Counter()
 // has increased by ticks.
 // This method is useful as an alternative to time.Sleep() when very short
 // pause periods are desired and it is undesirable to have the current
 // thread/goroutine descheduled.
-func Pause(ticks int64)
+// On AMD64 CPUs we spin using the PAUSE instruction, on ARM64 CPUs using
+// YIELD
+func Pause(ticks int64) {
+	pause(ticks)
+}
@@ -0,0 +1,72 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+//go:build !purego
+// +build !purego
+
+package ftime
+
+import (
+	"github.com/fmstephe/flib/fcpu"
+)
+
+func counter() (count int64)
+
+func pause(ticks int64)
+
+func counterStart() (count int64)
+
+func rdtscp() (count int64, processor int64)
+
+func fencedCounter() (count int64)
+
+var hasRDTSCP = fcpu.Detect().RDTSCP
+
+func counterEnd() (count int64) {
+	if hasRDTSCP {
+		count, _ = rdtscp()
+		return count
+	}
+	return fencedCounter()
+}
+
+// Linux stores the CPU number in the low 12 bits of IA32_TSC_AUX and
+// the NUMA node above them, we return the whole value.
+func counterEndProcessor() (count int64, processor int64) {
+	if hasRDTSCP {
+		return rdtscp()
+	}
+	return fencedCounter(), -1
+}
+
+func counterSteady() bool {
+	return fcpu.Detect().InvariantTSC
+}
+
+func counterSMPMonotonic() bool {
+	return fcpu.Detect().InvariantTSC
+}
+
+// The TSC frequency can be found from the crystal clock in CPUID leaf
+// 0x15, or failing that the base frequency in leaf 0x16. Neither is
+// reported by many CPUs, or by most hypervisors.
+func counterFrequency() (hz int64, ok bool) {
+	if !counterSteady() {
+		return 0, false
+	}
+	maxLeaf, _, _, _ := fcpu.CPUID(0, 0)
+	if maxLeaf >= 0x15 {
+		denominator, numerator, crystal, _ := fcpu.CPUID(0x15, 0)
+		if denominator != 0 && numerator != 0 && crystal != 0 {
+			return int64(crystal) * int64(numerator) / int64(denominator), true
+		}
+	}
+	if maxLeaf >= 0x16 {
+		baseMHz, _, _, _ := fcpu.CPUID(0x16, 0)
+		if baseMHz&0xFFFF != 0 {
+			return int64(baseMHz&0xFFFF) * 1000 * 1000, true
+		}
+	}
+	return 0, false
+}
@@ -1,23 +1,14 @@
-TEXT ·Counter(SB),$0-8
+//go:build !purego
+// +build !purego
+
+TEXT ·counter(SB),$0-8
 	RDTSC
         SHLQ    $32, DX
         ADDQ    DX, AX
 	MOVQ	AX, count+0(FP)
         RET
 
-TEXT ·cpuid(SB),$0-24
-	MOVL	eaxi+0(FP), AX
-	MOVL	$0, BX
-	MOVL	$0, CX
-	MOVL	$0, DX
-	CPUID
-        MOVL	AX, eax+8(FP)
-        MOVL	BX, ebx+12(FP) // Do I need to preserve this?
-        MOVL	CX, ecx+16(FP)
-	MOVL	DX, edx+20(FP)	
-        RET
-
-TEXT ·Pause(SB),$0-8
+TEXT ·pause(SB),$0-8
 	MOVQ	ticks+0(FP), BX
 	RDTSC
         SHLQ    $32, DX
@@ -31,3 +22,35 @@ testTick:
 	CMPQ	BX, AX
 	JGT	testTick
         RET
+
+// LFENCE waits for every preceding instruction to complete before RDTSC
+// executes
+TEXT ·counterStart(SB),$0-8
+	LFENCE
+	RDTSC
+	SHLQ	$32, DX
+	ADDQ	DX, AX
+	MOVQ	AX, count+0(FP)
+	RET
+
+// RDTSCP waits for every preceding instruction to complete, LFENCE stops
+// following instructions starting before RDTSCP has executed. The
+// processor ID is read from IA32_TSC_AUX into CX
+TEXT ·rdtscp(SB),$0-16
+	RDTSCP
+	LFENCE
+	SHLQ	$32, DX
+	ADDQ	DX, AX
+	MOVQ	AX, count+0(FP)
+	MOVQ	CX, processor+8(FP)
+	RET
+
+// Used for CounterEnd when RDTSCP is unavailable
+TEXT ·fencedCounter(SB),$0-8
+	LFENCE
+	RDTSC
+	LFENCE
+	SHLQ	$32, DX
+	ADDQ	DX, AX
+	MOVQ	AX, count+0(FP)
+	RET
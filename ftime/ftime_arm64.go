@@ -0,0 +1,38 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+//go:build !purego
+// +build !purego
+
+package ftime
+
+func counter() (count int64)
+
+func pause(ticks int64)
+
+// The generic timer ticks at the fixed frequency found in CNTFRQ_EL0
+func counterSteady() bool {
+	return true
+}
+
+// Every CPU reads the same system counter
+func counterSMPMonotonic() bool {
+	return true
+}
+
+func counterFreq() (hz int64)
+
+func counterFrequency() (hz int64, ok bool) {
+	hz = counterFreq()
+	return hz, hz != 0
+}
+
+func counterStart() (count int64)
+
+func counterEnd() (count int64)
+
+// There is no cheap way to find the current CPU
+func counterEndProcessor() (count int64, processor int64) {
+	return counterEnd(), -1
+}
@@ -0,0 +1,47 @@
+//go:build !purego
+// +build !purego
+
+#include "textflag.h"
+
+// The ISB stops the counter being read early, out of order with the
+// instructions before it.
+TEXT ·counter(SB),NOSPLIT,$0-8
+	ISB	$15
+	MRS	CNTVCT_EL0, R0
+	MOVD	R0, count+0(FP)
+	RET
+
+TEXT ·pause(SB),NOSPLIT,$0-8
+	MOVD	ticks+0(FP), R1
+	ISB	$15
+	MRS	CNTVCT_EL0, R0
+	ADD	R0, R1, R1 // Target ticks lives in R1
+testTick:
+	YIELD
+	ISB	$15
+	MRS	CNTVCT_EL0, R0
+	CMP	R1, R0
+	BLT	testTick
+	RET
+
+TEXT ·counterFreq(SB),NOSPLIT,$0-8
+	MRS	CNTFRQ_EL0, R0
+	MOVD	R0, hz+0(FP)
+	RET
+
+// The first ISB stops the counter being read before the preceding
+// instructions, the second stops the following instructions starting
+// before the counter is read.
+TEXT ·counterStart(SB),NOSPLIT,$0-8
+	ISB	$15
+	MRS	CNTVCT_EL0, R0
+	ISB	$15
+	MOVD	R0, count+0(FP)
+	RET
+
+TEXT ·counterEnd(SB),NOSPLIT,$0-8
+	ISB	$15
+	MRS	CNTVCT_EL0, R0
+	ISB	$15
+	MOVD	R0, count+0(FP)
+	RET
@@ -0,0 +1,54 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+//go:build (!amd64 && !arm64) || purego
+// +build !amd64,!arm64 purego
+
+package ftime
+
+import (
+	"time"
+)
+
+// The pure Go implementation, used on architectures without an assembly
+// implementation or when built with the purego tag.
+//
+// Counter is the number of nanoseconds, on the monotonic clock, since
+// the package was initialised
+var start = time.Now()
+
+func counter() (count int64) {
+	return int64(time.Since(start))
+}
+
+func counterStart() (count int64) {
+	return counter()
+}
+
+func counterEnd() (count int64) {
+	return counter()
+}
+
+func counterEndProcessor() (count int64, processor int64) {
+	return counter(), -1
+}
+
+func pause(ticks int64) {
+	target := counter() + ticks
+	for counter() < target {
+	}
+}
+
+func counterSteady() bool {
+	return true
+}
+
+func counterSMPMonotonic() bool {
+	return true
+}
+
+// The counter counts nanoseconds
+func counterFrequency() (hz int64, ok bool) {
+	return 1000 * 1000 * 1000, true
+}
@@ -0,0 +1,370 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+// Package rate provides rate limiters measured by ftime.Counter(), or an
+// injected ftime.Clock, which never allocate.
+//
+// Limiter and SharedLimiter use the virtual scheduling form of the leaky
+// bucket, the generic cell rate algorithm. Each limiter holds a
+// theoretical arrival time which advances by one interval for each token
+// taken, and never falls behind the present. Tokens are available while
+// the theoretical arrival time is less than burst intervals ahead of the
+// present. This behaves exactly as a token bucket holding up to burst
+// tokens, refilled at one token per interval, but only a single value
+// needs to be updated, which lets SharedLimiter update it with a single
+// CAS.
+//
+// Queue and SharedQueue use the queue form of the leaky bucket. Work
+// joins a queue of bounded capacity and is released from it at a
+// constant rate, with no bursts. Work which would overflow the queue is
+// refused.
+package rate
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/fmstephe/flib/fsync/padded"
+	"github.com/fmstephe/flib/ftime"
+)
+
+// Waits shorter than this are spent spinning in ftime.Pause(), longer
+// waits sleep.
+const SpinLimit = 50 * time.Microsecond
+
+type bucket struct {
+	// The number of clock ticks per token
+	interval int64
+	burst    int64
+	// How far ahead of the present the theoretical arrival time may get
+	limit        int64
+	clock        ftime.Clock
+	ticksPerNano float64
+}
+
+func newBucket(perSecond float64, burst int64, clock ftime.Clock) (bucket, error) {
+	var b bucket
+	ticksPerSecond := clock.TicksPerNanosecond() * float64(time.Second)
+	if perSecond <= 0 {
+		return b, errors.New(fmt.Sprintf("Rate (%f) must be greater than 0", perSecond))
+	}
+	if burst < 1 {
+		return b, errors.New(fmt.Sprintf("Burst (%d) must be at least 1", burst))
+	}
+	if perSecond > ticksPerSecond {
+		return b, errors.New(fmt.Sprintf("Rate (%f) must be no more than the clock's %f ticks per second", perSecond, ticksPerSecond))
+	}
+	b.interval = int64(ticksPerSecond / perSecond)
+	b.burst = burst
+	b.limit = burst * b.interval
+	b.clock = clock
+	b.ticksPerNano = clock.TicksPerNanosecond()
+	return b, nil
+}
+
+// The number of clock ticks per token, the rate is rounded so that this
+// is a whole number.
+func (b *bucket) Interval() int64 {
+	return b.interval
+}
+
+func (b *bucket) Burst() int64 {
+	return b.burst
+}
+
+// Calculates the new theoretical arrival time after n tokens are taken
+// at now, and the number of ticks until the tokens are available.
+func (b *bucket) take(tat, now, n int64) (next, delay int64) {
+	if tat < now {
+		tat = now
+	}
+	next = tat + n*b.interval
+	delay = next - now - b.limit
+	if delay < 0 {
+		delay = 0
+	}
+	return next, delay
+}
+
+func (b *bucket) tokens(tat, now int64) int64 {
+	if tat < now {
+		tat = now
+	}
+	return (b.limit - (tat - now)) / b.interval
+}
+
+// Spins for short delays, sleeps for long ones.
+func (b *bucket) wait(delay int64) time.Duration {
+	if delay <= 0 {
+		return 0
+	}
+	d := time.Duration(float64(delay) / b.ticksPerNano)
+	if d < SpinLimit {
+		b.clock.Pause(delay)
+	} else {
+		b.clock.Sleep(d)
+	}
+	return d
+}
+
+// A Limiter is a rate limiter for use by a single goroutine.
+type Limiter struct {
+	bucket
+	tat int64
+}
+
+// Creates a Limiter allowing perSecond tokens per second, measured by
+// ftime.Counter(), with up to burst tokens available at once. The
+// limiter starts full.
+func NewLimiter(perSecond float64, burst int64) (*Limiter, error) {
+	return NewLimiterClock(perSecond, burst, ftime.RealClock{})
+}
+
+// Creates a Limiter measured by clock's Counter(), waits pause or sleep
+// on clock.
+func NewLimiterClock(perSecond float64, burst int64, clock ftime.Clock) (*Limiter, error) {
+	b, err := newBucket(perSecond, burst, clock)
+	if err != nil {
+		return nil, err
+	}
+	return &Limiter{bucket: b, tat: clock.Counter()}, nil
+}
+
+// Takes n tokens if they are all available now. Returns false, taking
+// nothing, if they are not.
+func (l *Limiter) Allow(n int64) bool {
+	next, delay := l.take(l.tat, l.clock.Counter(), n)
+	if delay > 0 {
+		return false
+	}
+	l.tat = next
+	return true
+}
+
+// Takes n tokens, which may not be available yet. Returns the number of
+// clock ticks until they are, the caller must not act until then. n may
+// be larger than burst.
+func (l *Limiter) Reserve(n int64) (delay int64) {
+	next, delay := l.take(l.tat, l.clock.Counter(), n)
+	l.tat = next
+	return delay
+}
+
+// Takes n tokens, waiting until they are available. Returns the time
+// waited.
+func (l *Limiter) Wait(n int64) time.Duration {
+	return l.wait(l.Reserve(n))
+}
+
+// The number of tokens available now, negative if tokens have been
+// reserved ahead of time.
+func (l *Limiter) Tokens() int64 {
+	return l.tokens(l.tat, l.clock.Counter())
+}
+
+// A SharedLimiter is a rate limiter which may be used by any number of
+// goroutines. Tokens are taken with a CAS, so a contended limiter costs
+// retries rather than locks.
+type SharedLimiter struct {
+	bucket
+	tat padded.Int64
+}
+
+// As NewLimiter, but safe for concurrent use.
+func NewSharedLimiter(perSecond float64, burst int64) (*SharedLimiter, error) {
+	return NewSharedLimiterClock(perSecond, burst, ftime.RealClock{})
+}
+
+// As NewLimiterClock, but safe for concurrent use. clock must be safe
+// for concurrent use too.
+func NewSharedLimiterClock(perSecond float64, burst int64, clock ftime.Clock) (*SharedLimiter, error) {
+	b, err := newBucket(perSecond, burst, clock)
+	if err != nil {
+		return nil, err
+	}
+	l := &SharedLimiter{bucket: b}
+	l.tat.Value = clock.Counter()
+	return l, nil
+}
+
+func (l *SharedLimiter) Allow(n int64) bool {
+	for {
+		tat := atomic.LoadInt64(&l.tat.Value)
+		next, delay := l.take(tat, l.clock.Counter(), n)
+		if delay > 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&l.tat.Value, tat, next) {
+			return true
+		}
+	}
+}
+
+func (l *SharedLimiter) Reserve(n int64) (delay int64) {
+	for {
+		tat := atomic.LoadInt64(&l.tat.Value)
+		next, delay := l.take(tat, l.clock.Counter(), n)
+		if atomic.CompareAndSwapInt64(&l.tat.Value, tat, next) {
+			return delay
+		}
+	}
+}
+
+func (l *SharedLimiter) Wait(n int64) time.Duration {
+	return l.wait(l.Reserve(n))
+}
+
+func (l *SharedLimiter) Tokens() int64 {
+	return l.tokens(atomic.LoadInt64(&l.tat.Value), l.clock.Counter())
+}
+
+// A Queue is a leaky bucket queue for use by a single goroutine. Work is
+// released at one unit per interval, after the work queued before it.
+type Queue struct {
+	b        bucket
+	capacity int64
+	// The time at which the next work queued will be released
+	tail int64
+}
+
+// Creates a Queue releasing perSecond units of work per second, measured
+// by ftime.Counter(), holding up to capacity units at once. The queue
+// starts empty.
+func NewQueue(perSecond float64, capacity int64) (*Queue, error) {
+	return NewQueueClock(perSecond, capacity, ftime.RealClock{})
+}
+
+// Creates a Queue measured by clock's Counter(), waits pause or sleep on
+// clock.
+func NewQueueClock(perSecond float64, capacity int64, clock ftime.Clock) (*Queue, error) {
+	b, err := newQueueBucket(perSecond, capacity, clock)
+	if err != nil {
+		return nil, err
+	}
+	return &Queue{b: b, capacity: capacity, tail: clock.Counter()}, nil
+}
+
+func newQueueBucket(perSecond float64, capacity int64, clock ftime.Clock) (bucket, error) {
+	if capacity < 1 {
+		return bucket{}, errors.New(fmt.Sprintf("Capacity (%d) must be at least 1", capacity))
+	}
+	return newBucket(perSecond, capacity, clock)
+}
+
+// Calculates the new tail after n units of work join the queue at now,
+// and the number of ticks until they are released. Returns false if
+// they would overflow the queue.
+func (b *bucket) enqueue(tail, now, n int64) (next, delay int64, ok bool) {
+	if tail < now {
+		tail = now
+	}
+	next = tail + n*b.interval
+	if next-now > b.limit {
+		return tail, 0, false
+	}
+	return next, tail - now, true
+}
+
+func (b *bucket) queued(tail, now int64) int64 {
+	if tail <= now {
+		return 0
+	}
+	return (tail - now + b.interval - 1) / b.interval
+}
+
+// The number of clock ticks per unit of work, the rate is rounded so
+// that this is a whole number.
+func (q *Queue) Interval() int64 {
+	return q.b.interval
+}
+
+func (q *Queue) Capacity() int64 {
+	return q.capacity
+}
+
+// Queues n units of work. Returns the number of clock ticks until the
+// work is released, the caller must not act until then. Returns false,
+// queueing nothing, if the work would overflow the queue, so work larger
+// than the capacity is always refused.
+func (q *Queue) Enqueue(n int64) (delay int64, ok bool) {
+	next, delay, ok := q.b.enqueue(q.tail, q.b.clock.Counter(), n)
+	q.tail = next
+	return delay, ok
+}
+
+// Queues n units of work, waiting until the work is released. Returns
+// the time waited, or false without waiting if the work would overflow
+// the queue.
+func (q *Queue) Wait(n int64) (time.Duration, bool) {
+	delay, ok := q.Enqueue(n)
+	if !ok {
+		return 0, false
+	}
+	return q.b.wait(delay), true
+}
+
+// The number of units of work queued and not yet released.
+func (q *Queue) Len() int64 {
+	return q.b.queued(q.tail, q.b.clock.Counter())
+}
+
+// A SharedQueue is a leaky bucket queue which may be used by any number
+// of goroutines. Work is queued with a CAS.
+type SharedQueue struct {
+	b        bucket
+	capacity int64
+	tail     padded.Int64
+}
+
+// As NewQueue, but safe for concurrent use.
+func NewSharedQueue(perSecond float64, capacity int64) (*SharedQueue, error) {
+	return NewSharedQueueClock(perSecond, capacity, ftime.RealClock{})
+}
+
+// As NewQueueClock, but safe for concurrent use. clock must be safe for
+// concurrent use too.
+func NewSharedQueueClock(perSecond float64, capacity int64, clock ftime.Clock) (*SharedQueue, error) {
+	b, err := newQueueBucket(perSecond, capacity, clock)
+	if err != nil {
+		return nil, err
+	}
+	q := &SharedQueue{b: b, capacity: capacity}
+	q.tail.Value = clock.Counter()
+	return q, nil
+}
+
+func (q *SharedQueue) Interval() int64 {
+	return q.b.interval
+}
+
+func (q *SharedQueue) Capacity() int64 {
+	return q.capacity
+}
+
+func (q *SharedQueue) Enqueue(n int64) (delay int64, ok bool) {
+	for {
+		tail := atomic.LoadInt64(&q.tail.Value)
+		next, delay, ok := q.b.enqueue(tail, q.b.clock.Counter(), n)
+		if !ok {
+			return 0, false
+		}
+		if atomic.CompareAndSwapInt64(&q.tail.Value, tail, next) {
+			return delay, true
+		}
+	}
+}
+
+func (q *SharedQueue) Wait(n int64) (time.Duration, bool) {
+	delay, ok := q.Enqueue(n)
+	if !ok {
+		return 0, false
+	}
+	return q.b.wait(delay), true
+}
+
+func (q *SharedQueue) Len() int64 {
+	return q.b.queued(atomic.LoadInt64(&q.tail.Value), q.b.clock.Counter())
+}
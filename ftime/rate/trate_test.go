@@ -0,0 +1,349 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package rate
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fmstephe/flib/ftime"
+)
+
+// A clock which only moves when told to, ticking once per nanosecond
+func newFakeClock() *ftime.FakeClock {
+	c := ftime.NewFakeClock(time.Now(), 1)
+	c.Advance(1000)
+	return c
+}
+
+// The behaviour shared by Limiter and SharedLimiter
+type limiter interface {
+	Allow(n int64) bool
+	Reserve(n int64) int64
+	Wait(n int64) time.Duration
+	Tokens() int64
+}
+
+func newLimiters(t *testing.T, perSecond float64, burst int64) (*ftime.FakeClock, []limiter) {
+	c := newFakeClock()
+	l, err := NewLimiterClock(perSecond, burst, c)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	sl, err := NewSharedLimiterClock(perSecond, burst, c)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return c, []limiter{l, sl}
+}
+
+func TestBurstAndRefill(t *testing.T) {
+	// A token every millisecond, up to 10 at once
+	c, limiters := newLimiters(t, 1000, 10)
+	for _, l := range limiters {
+		for i := 0; i < 10; i++ {
+			if !l.Allow(1) {
+				t.Fatalf("%T: expected token %d of burst", l, i)
+			}
+		}
+		if l.Allow(1) {
+			t.Errorf("%T: expected empty bucket", l)
+		}
+		if l.Tokens() != 0 {
+			t.Errorf("%T: expected 0 tokens, found %d", l, l.Tokens())
+		}
+	}
+	c.AdvanceDuration(3500 * time.Microsecond)
+	for _, l := range limiters {
+		if l.Tokens() != 3 {
+			t.Errorf("%T: expected 3 tokens, found %d", l, l.Tokens())
+		}
+		if l.Allow(4) {
+			t.Errorf("%T: expected 4 tokens to be refused", l)
+		}
+		if !l.Allow(3) {
+			t.Errorf("%T: expected 3 tokens to be allowed", l)
+		}
+	}
+	// The bucket never holds more than burst tokens
+	c.AdvanceDuration(time.Hour)
+	for _, l := range limiters {
+		if l.Tokens() != 10 || l.Allow(11) || !l.Allow(10) {
+			t.Errorf("%T: expected bucket to refill to 10 tokens", l)
+		}
+	}
+}
+
+func TestReserve(t *testing.T) {
+	c, limiters := newLimiters(t, 1000, 5)
+	for _, l := range limiters {
+		if delay := l.Reserve(5); delay != 0 {
+			t.Errorf("%T: expected no delay, found %d", l, delay)
+		}
+		// Reservations run ahead, each waiting a further interval
+		for i := int64(1); i <= 3; i++ {
+			expected := i * int64(time.Millisecond)
+			if delay := l.Reserve(1); delay != expected {
+				t.Errorf("%T: expected delay %d, found %d", l, expected, delay)
+			}
+		}
+		if l.Tokens() != -3 {
+			t.Errorf("%T: expected -3 tokens, found %d", l, l.Tokens())
+		}
+		// More than burst can be reserved
+		if delay := l.Reserve(20); delay != 23*int64(time.Millisecond) {
+			t.Errorf("%T: expected delay of 23ms, found %d", l, delay)
+		}
+	}
+	c.AdvanceDuration(23 * time.Millisecond)
+	for _, l := range limiters {
+		if l.Tokens() != 0 || l.Allow(1) {
+			t.Errorf("%T: expected reservations to be paid off, with no tokens, found %d", l, l.Tokens())
+		}
+	}
+}
+
+// Waits pause, or sleep, on the clock. The fake clock advances by the
+// time waited
+func TestWait(t *testing.T) {
+	for _, shared := range []bool{false, true} {
+		c := newFakeClock()
+		var l limiter
+		var err error
+		if shared {
+			l, err = NewSharedLimiterClock(100*1000, 1, c)
+		} else {
+			l, err = NewLimiterClock(100*1000, 1, c)
+		}
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if d := l.Wait(1); d != 0 || c.Pauses() != 0 {
+			t.Errorf("%T: expected no wait, waited %s", l, d)
+		}
+		if d := l.Wait(1); d != 10*time.Microsecond {
+			t.Errorf("%T: expected to wait 10µs, waited %s", l, d)
+		}
+		// The first wait advanced the clock, so only 100µs more
+		if d := l.Wait(10); d != 100*time.Microsecond {
+			t.Errorf("%T: expected to wait 100µs, waited %s", l, d)
+		}
+		if c.Pauses() != 2 || c.Paused() != int64(110*time.Microsecond) {
+			t.Errorf("%T: expected 2 waits of 110µs, found %d waits of %d", l, c.Pauses(), c.Paused())
+		}
+		if l.Tokens() != 0 {
+			t.Errorf("%T: expected no tokens, found %d", l, l.Tokens())
+		}
+	}
+}
+
+func TestCounterLimiter(t *testing.T) {
+	l, err := NewLimiter(10*1000, 10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	start := time.Now()
+	for i := 0; i < 110; i++ {
+		l.Wait(1)
+	}
+	// 10 tokens are available at once, 100 more take 10ms
+	if elapsed := time.Since(start); elapsed < 9*time.Millisecond {
+		t.Errorf("Expected 110 tokens to take at least 10ms, took %s", elapsed)
+	}
+}
+
+// Many goroutines share a limiter on a fixed clock, exactly burst tokens
+// are handed out
+func TestSharedLimiterConcurrent(t *testing.T) {
+	l, err := NewSharedLimiterClock(1000, 1000, newFakeClock())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var allowed int64
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				if l.Allow(1) {
+					atomic.AddInt64(&allowed, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 1000 {
+		t.Errorf("Expected 1000 tokens allowed, found %d", allowed)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	c := newFakeClock()
+	if _, err := NewLimiterClock(0, 1, c); err == nil {
+		t.Errorf("Expected error for rate of 0")
+	}
+	if _, err := NewLimiterClock(1, 0, c); err == nil {
+		t.Errorf("Expected error for burst of 0")
+	}
+	if _, err := NewSharedLimiterClock(2*float64(time.Second), 1, c); err == nil {
+		t.Errorf("Expected error for rate faster than the clock")
+	}
+}
+
+func TestNoAllocation(t *testing.T) {
+	_, limiters := newLimiters(t, 1000, 10)
+	for _, l := range limiters {
+		allocs := testing.AllocsPerRun(100, func() {
+			l.Allow(1)
+			l.Reserve(1)
+			l.Tokens()
+		})
+		if allocs != 0 {
+			t.Errorf("%T: expected no allocations, found %f", l, allocs)
+		}
+	}
+}
+
+// The behaviour shared by Queue and SharedQueue
+type queue interface {
+	Enqueue(n int64) (int64, bool)
+	Wait(n int64) (time.Duration, bool)
+	Len() int64
+}
+
+func newQueues(t *testing.T, perSecond float64, capacity int64) (*ftime.FakeClock, []queue) {
+	c := newFakeClock()
+	q, err := NewQueueClock(perSecond, capacity, c)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	sq, err := NewSharedQueueClock(perSecond, capacity, c)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return c, []queue{q, sq}
+}
+
+func TestQueueConstantRate(t *testing.T) {
+	// A unit every millisecond, up to 5 queued
+	c, queues := newQueues(t, 1000, 5)
+	for _, q := range queues {
+		// Work is released one interval per unit after the work before it
+		for i, expected := range []time.Duration{0, time.Millisecond, 2 * time.Millisecond} {
+			n := int64(1 + i/2)
+			if delay, ok := q.Enqueue(n); !ok || delay != int64(expected) {
+				t.Errorf("%T: expected %d units released after %s, found %d %t", q, n, expected, delay, ok)
+			}
+		}
+		if q.Len() != 4 {
+			t.Errorf("%T: expected 4 units queued, found %d", q, q.Len())
+		}
+		if delay, ok := q.Enqueue(1); !ok || delay != int64(4*time.Millisecond) {
+			t.Errorf("%T: expected last unit released after 4ms, found %d %t", q, delay, ok)
+		}
+		if _, ok := q.Enqueue(1); ok {
+			t.Errorf("%T: expected full queue to refuse work", q)
+		}
+	}
+	c.AdvanceDuration(2500 * time.Microsecond)
+	for _, q := range queues {
+		if q.Len() != 3 {
+			t.Errorf("%T: expected 3 units queued, found %d", q, q.Len())
+		}
+		if _, ok := q.Enqueue(3); ok {
+			t.Errorf("%T: expected 3 units to overflow the queue", q)
+		}
+		if delay, ok := q.Enqueue(2); !ok || delay != int64(2500*time.Microsecond) {
+			t.Errorf("%T: expected 2 units released after 2.5ms, found %d %t", q, delay, ok)
+		}
+	}
+	// An idle queue empties, but never saves up a burst
+	c.AdvanceDuration(time.Hour)
+	for _, q := range queues {
+		if q.Len() != 0 {
+			t.Errorf("%T: expected empty queue, found %d", q, q.Len())
+		}
+		if _, ok := q.Enqueue(6); ok {
+			t.Errorf("%T: expected work larger than the capacity to be refused", q)
+		}
+		if delay, ok := q.Enqueue(1); !ok || delay != 0 {
+			t.Errorf("%T: expected no delay, found %d %t", q, delay, ok)
+		}
+		if delay, ok := q.Enqueue(1); !ok || delay != int64(time.Millisecond) {
+			t.Errorf("%T: expected delay of 1ms, found %d %t", q, delay, ok)
+		}
+	}
+}
+
+func TestQueueWait(t *testing.T) {
+	c, queues := newQueues(t, 100*1000, 10)
+	for _, q := range queues {
+		if d, ok := q.Wait(2); !ok || d != 0 {
+			t.Errorf("%T: expected no wait, waited %s %t", q, d, ok)
+		}
+		// The first work takes 20µs to release
+		if d, ok := q.Wait(1); !ok || d != 20*time.Microsecond {
+			t.Errorf("%T: expected to wait 20µs, waited %s %t", q, d, ok)
+		}
+		if d, ok := q.Wait(11); ok || d != 0 {
+			t.Errorf("%T: expected refusal without waiting, waited %s %t", q, d, ok)
+		}
+	}
+	if c.Pauses() != 2 || c.Paused() != int64(40*time.Microsecond) {
+		t.Errorf("Expected 2 waits of 40µs, found %d waits of %d", c.Pauses(), c.Paused())
+	}
+}
+
+// Many goroutines share a queue on a fixed clock, exactly capacity units
+// are queued
+func TestSharedQueueConcurrent(t *testing.T) {
+	q, err := NewSharedQueueClock(1000, 1000, newFakeClock())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var queued int64
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				if _, ok := q.Enqueue(1); ok {
+					atomic.AddInt64(&queued, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if queued != 1000 || q.Len() != 1000 {
+		t.Errorf("Expected 1000 units queued, found %d and length %d", queued, q.Len())
+	}
+}
+
+func TestQueueErrors(t *testing.T) {
+	c := newFakeClock()
+	if _, err := NewQueueClock(0, 1, c); err == nil {
+		t.Errorf("Expected error for rate of 0")
+	}
+	if _, err := NewSharedQueueClock(1, 0, c); err == nil {
+		t.Errorf("Expected error for capacity of 0")
+	}
+}
+
+func TestQueueNoAllocation(t *testing.T) {
+	_, queues := newQueues(t, 1000, 10)
+	for _, q := range queues {
+		allocs := testing.AllocsPerRun(100, func() {
+			q.Enqueue(1)
+			q.Len()
+		})
+		if allocs != 0 {
+			t.Errorf("%T: expected no allocations, found %f", q, allocs)
+		}
+	}
+}
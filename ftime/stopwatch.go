@@ -0,0 +1,78 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package ftime
+
+import (
+	"time"
+)
+
+// A Stopwatch accumulates the Counter() ticks elapsed between calls to
+// Start() and Stop(). Readings are taken with CounterStart() and
+// CounterEnd(), so the timed code can't be reordered outside them. The
+// zero value is a stopped Stopwatch with nothing elapsed.
+type Stopwatch struct {
+	start   int64
+	elapsed int64
+	laps    int64
+	running bool
+}
+
+// Starts timing, does nothing if already running.
+func (s *Stopwatch) Start() {
+	if s.running {
+		return
+	}
+	s.running = true
+	s.start = CounterStart()
+}
+
+// Stops timing and returns the ticks elapsed since Start(). Returns 0
+// if not running.
+func (s *Stopwatch) Stop() (lap int64) {
+	if !s.running {
+		return 0
+	}
+	lap = CounterEnd() - s.start
+	s.running = false
+	s.elapsed += lap
+	s.laps++
+	return lap
+}
+
+// Stops timing, clearing everything elapsed so far.
+func (s *Stopwatch) Reset() {
+	*s = Stopwatch{}
+}
+
+func (s *Stopwatch) Running() bool {
+	return s.running
+}
+
+// The total ticks elapsed across every Start()/Stop(), including the
+// current one if running.
+func (s *Stopwatch) Elapsed() int64 {
+	if s.running {
+		return s.elapsed + Counter() - s.start
+	}
+	return s.elapsed
+}
+
+// As Elapsed(), converted with TicksToDuration().
+func (s *Stopwatch) ElapsedDuration() time.Duration {
+	return TicksToDuration(s.Elapsed())
+}
+
+// The number of times the Stopwatch has been stopped.
+func (s *Stopwatch) Laps() int64 {
+	return s.laps
+}
+
+// The mean ticks elapsed per lap.
+func (s *Stopwatch) Mean() int64 {
+	if s.laps == 0 {
+		return 0
+	}
+	return s.elapsed / s.laps
+}
@@ -0,0 +1,112 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package ftime
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// The background goroutines may not be scheduled promptly, so we allow
+// much more than the clocks' resolution
+const clockSlack = 50 * time.Millisecond
+
+func checkNear(t *testing.T, name string, found, expected int64) {
+	t.Helper()
+	if diff := time.Duration(found - expected); diff < -clockSlack || diff > clockSlack {
+		t.Errorf("%s: expected %d, found %d, a difference of %s", name, expected, found, diff)
+	}
+}
+
+func TestCachedClock(t *testing.T) {
+	c := NewCachedClock(100 * time.Microsecond)
+	defer c.Close()
+	first := c.Nanotime()
+	time.Sleep(20 * time.Millisecond)
+	second := c.Nanotime()
+	if second <= first {
+		t.Errorf("Cached time did not advance from %d", first)
+	}
+	checkNear(t, "Nanotime", second, nanotime())
+	now := time.Now()
+	if diff := c.Now().Sub(now); diff < -clockSlack || diff > clockSlack {
+		t.Errorf("Cached Now() %s differs from time.Now() by %s", c.Now(), diff)
+	}
+}
+
+func TestCachedClockClose(t *testing.T) {
+	c := NewCachedClock(100 * time.Microsecond)
+	c.Close()
+	c.Close()
+	// Allow the goroutine to see the close
+	time.Sleep(5 * time.Millisecond)
+	stopped := c.Nanotime()
+	time.Sleep(5 * time.Millisecond)
+	if c.Nanotime() != stopped {
+		t.Errorf("Cached time advanced after Close()")
+	}
+}
+
+func TestCachedNow(t *testing.T) {
+	time.Sleep(5 * time.Millisecond)
+	checkNear(t, "CachedNanotime", CachedNanotime(), nanotime())
+	if diff := CachedNow().Sub(time.Now()); diff < -clockSlack || diff > clockSlack {
+		t.Errorf("CachedNow() differs from time.Now() by %s", diff)
+	}
+}
+
+func TestCounterClock(t *testing.T) {
+	c := NewCounterClock(time.Millisecond)
+	defer c.Close()
+	last := c.Nanotime()
+	deadline := time.Now().Add(50 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		now := c.Nanotime()
+		if now < last {
+			t.Fatalf("CounterClock ran backwards from %d to %d", last, now)
+		}
+		last = now
+	}
+	checkNear(t, "Nanotime", c.Nanotime(), nanotime())
+}
+
+// A clock whose rate is wrong runs ahead, it must be slowed rather than
+// stepped back
+func TestCounterClockAhead(t *testing.T) {
+	c := NewCounterClock(time.Hour)
+	defer c.Close()
+	base := (*counterBase)(c.base)
+	base.ticksPerNano /= 2
+	base.measured /= 2
+	time.Sleep(5 * time.Millisecond)
+	before := c.Nanotime()
+	c.resyncBase()
+	after := c.Nanotime()
+	if after < before {
+		t.Errorf("CounterClock stepped back from %d to %d", before, after)
+	}
+	next := (*counterBase)(c.base)
+	if next.ticksPerNano <= next.measured {
+		t.Errorf("Expected clock to be slowed, rate %f, measured %f", next.ticksPerNano, next.measured)
+	}
+}
+
+// A resync which slows the clock down may extrapolate readings behind
+// those already returned, the last reading is returned until it passes
+func TestCounterClockSlowerResync(t *testing.T) {
+	c := NewCounterClock(time.Hour)
+	defer c.Close()
+	before := c.Nanotime()
+	base := *(*counterBase)(c.base)
+	base.count = counter()
+	base.nanos = before - int64(time.Millisecond)
+	base.ticksPerNano *= 2
+	atomic.StorePointer(&c.base, unsafe.Pointer(&base))
+	if after := c.Nanotime(); after < before {
+		t.Errorf("CounterClock ran backwards from %d to %d", before, after)
+	}
+}
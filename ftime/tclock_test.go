@@ -0,0 +1,52 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package ftime
+
+import (
+	"testing"
+	"time"
+)
+
+var (
+	_ Clock = RealClock{}
+	_ Clock = (*FakeClock)(nil)
+)
+
+func TestRealClock(t *testing.T) {
+	var c Clock = RealClock{}
+	start := c.Counter()
+	c.Pause(1000)
+	if c.Counter()-start < 1000 {
+		t.Errorf("Expected to pause for at least 1000 ticks")
+	}
+	if c.TicksPerNanosecond() != TicksPerNanosecond() {
+		t.Errorf("Expected %f ticks per nanosecond, found %f", TicksPerNanosecond(), c.TicksPerNanosecond())
+	}
+	if d := time.Since(c.Now()); d < 0 || d > time.Second {
+		t.Errorf("Expected Now() to match time.Now(), differs by %s", d)
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start, 2)
+	if c.Counter() != 0 || !c.Now().Equal(start) {
+		t.Errorf("Expected clock at 0, %s, found %d, %s", start, c.Counter(), c.Now())
+	}
+	c.Advance(2000)
+	if c.Counter() != 2000 || !c.Now().Equal(start.Add(time.Microsecond)) {
+		t.Errorf("Expected clock at 2000, found %d, %s", c.Counter(), c.Now())
+	}
+	c.AdvanceDuration(time.Microsecond)
+	if c.Counter() != 4000 {
+		t.Errorf("Expected clock at 4000, found %d", c.Counter())
+	}
+	c.Pause(100)
+	c.Pause(0)
+	c.Sleep(time.Microsecond)
+	if c.Counter() != 6100 || c.Pauses() != 3 || c.Paused() != 2100 {
+		t.Errorf("Expected clock at 6100 after 3 pauses of 2100 ticks, found %d after %d pauses of %d ticks", c.Counter(), c.Pauses(), c.Paused())
+	}
+}
@@ -6,6 +6,7 @@ package ftime
 
 import (
 	"testing"
+	"time"
 
 	. "github.com/fmstephe/flib/fstrconv"
 )
@@ -54,3 +55,70 @@ func TestPause(t *testing.T) {
 		}
 	}
 }
+
+func TestCounterFrequency(t *testing.T) {
+	hz, ok := CounterFrequency()
+	if !ok {
+		t.Skip("Counter frequency not reported")
+	}
+	reported := float64(hz) / float64(time.Second)
+	measured := Calibrate(50 * time.Millisecond)
+	if measured < reported*0.9 || measured > reported*1.1 {
+		t.Errorf("Reported %f ticks per nanosecond, measured %f", reported, measured)
+	}
+}
+
+func TestCalibrate(t *testing.T) {
+	rate := Calibrate(20 * time.Millisecond)
+	if rate <= 0 {
+		t.Fatalf("Expected positive ticks per nanosecond, found %f", rate)
+	}
+	if TicksPerNanosecond() != rate {
+		t.Errorf("Expected calibrated rate %f, found %f", rate, TicksPerNanosecond())
+	}
+}
+
+func TestTicksDurationConversion(t *testing.T) {
+	for d := time.Microsecond; d <= time.Second; d *= 10 {
+		ticks := DurationToTicks(d)
+		if ticks <= 0 {
+			t.Errorf("Expected positive ticks for %s, found %d", d, ticks)
+		}
+		// Allow for rounding in both conversions
+		if found := TicksToDuration(ticks); found < d-2 || found > d+2 {
+			t.Errorf("Expected %s, found %s from %d ticks", d, found, ticks)
+		}
+	}
+}
+
+func TestPauseDuration(t *testing.T) {
+	for d := time.Microsecond; d <= 10*time.Millisecond; d *= 10 {
+		start := time.Now()
+		PauseDuration(d)
+		// The calibration may be slightly off
+		if elapsed := time.Since(start); elapsed < d*9/10 {
+			t.Errorf("Paused for %s, expected at least %s", elapsed, d)
+		}
+	}
+}
+
+func TestCounterStartEnd(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		start := CounterStart()
+		end := CounterEnd()
+		if end < start {
+			t.Fatalf("CounterEnd() %s before CounterStart() %s", ItoaComma(end), ItoaComma(start))
+		}
+	}
+}
+
+func TestCounterEndProcessor(t *testing.T) {
+	start := CounterStart()
+	end, processor := CounterEndProcessor()
+	if end < start {
+		t.Errorf("CounterEndProcessor() %s before CounterStart() %s", ItoaComma(end), ItoaComma(start))
+	}
+	if processor < -1 {
+		t.Errorf("Invalid processor %d", processor)
+	}
+}
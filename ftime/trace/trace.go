@@ -0,0 +1,252 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+// Package trace records timed spans of code from hot paths, for viewing
+// in Perfetto or chrome://tracing.
+//
+// Each goroutine, usually locked to an OS thread, records spans through
+// its own Recorder. A span is written, without allocating, into the
+// Recorder's ByteChunkQ as the id of a registered name, its start and
+// end ftime.Counter() ticks and the processor it ended on. A Tracer
+// collects the spans from every Recorder and writes them out as Chrome
+// trace-event JSON. If a Recorder's queue is full the span is dropped
+// and counted, recording never blocks.
+//
+// While a Tracer is disabled recording a span costs a single atomic
+// load.
+package trace
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fmstephe/flib/fsync/padded"
+	"github.com/fmstephe/flib/ftime"
+	"github.com/fmstephe/flib/queues/spscq"
+)
+
+// Identifies a span name registered with a Tracer
+type SpanID uint32
+
+// The layout of a recorded span
+//
+//	[0:8]   start ticks
+//	[8:16]  end ticks
+//	[16:20] SpanID
+//	[20:28] processor, see ftime.CounterEndProcessor()
+//	[28:32] unused
+const spanBytes = 32
+
+// A span as collected by a Tracer
+type Span struct {
+	ID        SpanID
+	Recorder  int
+	Start     int64
+	End       int64
+	Processor int64
+}
+
+// Returned by Begin while the Tracer is disabled. Any Counter() value,
+// including 0, may be a real start.
+const Disabled int64 = math.MinInt64
+
+// A Recorder records spans for a single goroutine. It must not be
+// shared between goroutines.
+type Recorder struct {
+	tracer  *Tracer
+	id      int
+	name    string
+	q       *spscq.ByteChunkQ
+	dropped padded.Int64
+}
+
+// Begins a span, returning its start ticks. If the Tracer is disabled
+// returns Disabled, and the matching End() records nothing.
+func (r *Recorder) Begin() (start int64) {
+	if !r.tracer.Enabled() {
+		return Disabled
+	}
+	return ftime.CounterStart()
+}
+
+// Ends the span begun at start, recording it as id.
+func (r *Recorder) End(id SpanID, start int64) {
+	if start == Disabled {
+		return
+	}
+	end, processor := ftime.CounterEndProcessor()
+	chunk := r.q.AcquireWrite()
+	if chunk == nil {
+		atomic.AddInt64(&r.dropped.Value, 1)
+		return
+	}
+	binary.LittleEndian.PutUint64(chunk, uint64(start))
+	binary.LittleEndian.PutUint64(chunk[8:], uint64(end))
+	binary.LittleEndian.PutUint32(chunk[16:], uint32(id))
+	binary.LittleEndian.PutUint64(chunk[20:], uint64(processor))
+	r.q.ReleaseWriteLazy()
+}
+
+// The number of spans dropped because the queue was full. Safe to call
+// from any goroutine.
+func (r *Recorder) Dropped() int64 {
+	return atomic.LoadInt64(&r.dropped.Value)
+}
+
+// A Tracer collects the spans recorded by its Recorders.
+type Tracer struct {
+	enabled   padded.Bool
+	mu        sync.Mutex
+	names     []string
+	recorders []*Recorder
+	// Used only while collecting
+	spans []Span
+	start int64
+}
+
+// Creates a disabled Tracer.
+func New() *Tracer {
+	return &Tracer{start: ftime.Counter()}
+}
+
+func (t *Tracer) Enable() {
+	t.enabled.Store(true)
+}
+
+func (t *Tracer) Disable() {
+	t.enabled.Store(false)
+}
+
+func (t *Tracer) Enabled() bool {
+	return t.enabled.Load()
+}
+
+// Registers a span name. Names are usually registered once at start up
+// and the returned SpanID used for every span recorded.
+func (t *Tracer) Name(name string) SpanID {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.names = append(t.names, name)
+	return SpanID(len(t.names) - 1)
+}
+
+// Creates a new Recorder, for use by a single goroutine, whose queue
+// holds size spans. Size must be a power of two. The name is shown as
+// the thread's name in the trace.
+func (t *Tracer) NewRecorder(name string, size int64) (*Recorder, error) {
+	q, err := spscq.NewByteChunkQ(size*spanBytes, 0, spanBytes)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r := &Recorder{tracer: t, id: len(t.recorders), name: name, q: q}
+	t.recorders = append(t.recorders, r)
+	return r, nil
+}
+
+// The number of spans dropped by all Recorders.
+func (t *Tracer) Dropped() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	dropped := int64(0)
+	for _, r := range t.recorders {
+		dropped += r.Dropped()
+	}
+	return dropped
+}
+
+// Reads every available span from the Recorders, keeping them until
+// Reset(). Should be called often enough that the Recorders' queues
+// don't fill. Returns the number of spans read. Must not be called
+// concurrently with itself, Spans(), WriteChrome() or Reset().
+func (t *Tracer) Collect() int64 {
+	t.mu.Lock()
+	recorders := t.recorders
+	t.mu.Unlock()
+	count := int64(0)
+	for _, r := range recorders {
+		count += r.q.Drain(func(chunk []byte) {
+			t.spans = append(t.spans, Span{
+				Start:     int64(binary.LittleEndian.Uint64(chunk)),
+				End:       int64(binary.LittleEndian.Uint64(chunk[8:])),
+				ID:        SpanID(binary.LittleEndian.Uint32(chunk[16:])),
+				Processor: int64(binary.LittleEndian.Uint64(chunk[20:])),
+				Recorder:  r.id,
+			})
+		})
+	}
+	return count
+}
+
+// The spans collected so far, grouped by Recorder.
+func (t *Tracer) Spans() []Span {
+	return t.spans
+}
+
+// Discards the spans collected so far.
+func (t *Tracer) Reset() {
+	t.spans = t.spans[:0]
+}
+
+// A Chrome trace event, see the Trace Event Format
+type event struct {
+	Name      string                 `json:"name"`
+	Phase     string                 `json:"ph"`
+	Timestamp float64                `json:"ts"`
+	Duration  float64                `json:"dur"`
+	PID       int                    `json:"pid"`
+	TID       int                    `json:"tid"`
+	Args      map[string]interface{} `json:"args,omitempty"`
+}
+
+type chromeTrace struct {
+	TraceEvents     []event `json:"traceEvents"`
+	DisplayTimeUnit string  `json:"displayTimeUnit"`
+}
+
+// Collects any available spans, then writes every collected span as
+// Chrome trace-event JSON. Each Recorder is shown as a thread, with
+// timestamps in microseconds since the Tracer was created.
+func (t *Tracer) WriteChrome(w io.Writer) error {
+	t.Collect()
+	t.mu.Lock()
+	names := t.names
+	recorders := t.recorders
+	t.mu.Unlock()
+	trace := chromeTrace{DisplayTimeUnit: "ns"}
+	for _, r := range recorders {
+		trace.TraceEvents = append(trace.TraceEvents, event{
+			Name:  "thread_name",
+			Phase: "M",
+			PID:   1,
+			TID:   r.id,
+			Args:  map[string]interface{}{"name": r.name},
+		})
+	}
+	for _, s := range t.spans {
+		name := "unknown"
+		if int(s.ID) < len(names) {
+			name = names[s.ID]
+		}
+		trace.TraceEvents = append(trace.TraceEvents, event{
+			Name:      name,
+			Phase:     "X",
+			Timestamp: t.micros(s.Start - t.start),
+			Duration:  t.micros(s.End - s.Start),
+			PID:       1,
+			TID:       s.Recorder,
+			Args:      map[string]interface{}{"processor": s.Processor},
+		})
+	}
+	return json.NewEncoder(w).Encode(trace)
+}
+
+func (t *Tracer) micros(ticks int64) float64 {
+	return float64(ftime.TicksToDuration(ticks)) / 1000
+}
@@ -0,0 +1,194 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"runtime"
+	"testing"
+
+	"github.com/fmstephe/flib/ftime"
+)
+
+func newRecorder(t *testing.T, tracer *Tracer, name string, size int64) *Recorder {
+	r, err := tracer.NewRecorder(name, size)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return r
+}
+
+func TestRecord(t *testing.T) {
+	tracer := New()
+	outer := tracer.Name("outer")
+	inner := tracer.Name("inner")
+	r := newRecorder(t, tracer, "worker", 16)
+	tracer.Enable()
+	o := r.Begin()
+	i := r.Begin()
+	ftime.Pause(1000)
+	r.End(inner, i)
+	r.End(outer, o)
+	if n := tracer.Collect(); n != 2 {
+		t.Fatalf("Expected to collect 2 spans, collected %d", n)
+	}
+	spans := tracer.Spans()
+	if spans[0].ID != inner || spans[1].ID != outer {
+		t.Errorf("Expected inner then outer span, found %v", spans)
+	}
+	if spans[0].Start < spans[1].Start || spans[0].End > spans[1].End {
+		t.Errorf("Expected inner span %v within outer span %v", spans[0], spans[1])
+	}
+	if spans[0].End-spans[0].Start < 1000 {
+		t.Errorf("Expected span of at least 1000 ticks, found %d", spans[0].End-spans[0].Start)
+	}
+	tracer.Reset()
+	if len(tracer.Spans()) != 0 {
+		t.Errorf("Expected Reset() to discard spans")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	tracer := New()
+	id := tracer.Name("span")
+	r := newRecorder(t, tracer, "worker", 16)
+	start := r.Begin()
+	if start != Disabled {
+		t.Errorf("Expected Begin() to return Disabled while disabled, found %d", start)
+	}
+	r.End(id, start)
+	// A span begun while disabled is not recorded, even if enabled since
+	tracer.Enable()
+	r.End(id, start)
+	tracer.Disable()
+	if n := tracer.Collect(); n != 0 {
+		t.Errorf("Expected no spans while disabled, collected %d", n)
+	}
+}
+
+// A counter, such as a fake or purego clock, may start at 0
+func TestRecordZeroStart(t *testing.T) {
+	tracer := New()
+	id := tracer.Name("span")
+	r := newRecorder(t, tracer, "worker", 16)
+	tracer.Enable()
+	r.End(id, 0)
+	if n := tracer.Collect(); n != 1 {
+		t.Fatalf("Expected to collect 1 span, collected %d", n)
+	}
+	if start := tracer.Spans()[0].Start; start != 0 {
+		t.Errorf("Expected span to start at 0, found %d", start)
+	}
+}
+
+func TestDropped(t *testing.T) {
+	tracer := New()
+	id := tracer.Name("span")
+	r := newRecorder(t, tracer, "worker", 4)
+	tracer.Enable()
+	for i := 0; i < 6; i++ {
+		r.End(id, r.Begin())
+	}
+	if r.Dropped() != 2 || tracer.Dropped() != 2 {
+		t.Errorf("Expected 2 dropped spans, found %d", tracer.Dropped())
+	}
+	if n := tracer.Collect(); n != 4 {
+		t.Errorf("Expected to collect 4 spans, collected %d", n)
+	}
+}
+
+func TestWriteChrome(t *testing.T) {
+	tracer := New()
+	id := tracer.Name("work")
+	a := newRecorder(t, tracer, "a", 16)
+	b := newRecorder(t, tracer, "b", 16)
+	tracer.Enable()
+	a.End(id, a.Begin())
+	b.End(id, b.Begin())
+	b.End(id, b.Begin())
+	var buf bytes.Buffer
+	if err := tracer.WriteChrome(&buf); err != nil {
+		t.Fatal(err.Error())
+	}
+	var trace chromeTrace
+	if err := json.Unmarshal(buf.Bytes(), &trace); err != nil {
+		t.Fatalf("Invalid JSON %s: %s", buf.String(), err)
+	}
+	threads := map[int]string{}
+	spans := map[int]int{}
+	for _, e := range trace.TraceEvents {
+		switch e.Phase {
+		case "M":
+			threads[e.TID] = e.Args["name"].(string)
+		case "X":
+			if e.Name != "work" || e.Timestamp < 0 || e.Duration < 0 {
+				t.Errorf("Unexpected event %v", e)
+			}
+			spans[e.TID]++
+		}
+	}
+	if threads[0] != "a" || threads[1] != "b" {
+		t.Errorf("Expected threads a and b, found %v", threads)
+	}
+	if spans[0] != 1 || spans[1] != 2 {
+		t.Errorf("Expected 1 span from a and 2 from b, found %v", spans)
+	}
+}
+
+// Recorders on other goroutines are collected while they record
+func TestConcurrent(t *testing.T) {
+	const spans = 10 * 1000
+	tracer := New()
+	id := tracer.Name("span")
+	r := newRecorder(t, tracer, "worker", 64)
+	tracer.Enable()
+	go func() {
+		for i := 0; i < spans; {
+			start := r.Begin()
+			before := r.Dropped()
+			r.End(id, start)
+			if r.Dropped() == before {
+				i++
+			} else {
+				runtime.Gosched()
+			}
+		}
+	}()
+	for collected := int64(0); collected < spans; {
+		n := tracer.Collect()
+		if n == 0 {
+			runtime.Gosched()
+		}
+		collected += n
+	}
+	for _, s := range tracer.Spans() {
+		if s.ID != id || s.End < s.Start {
+			t.Fatalf("Invalid span %v", s)
+		}
+	}
+}
+
+func TestNoAllocation(t *testing.T) {
+	tracer := New()
+	id := tracer.Name("span")
+	r := newRecorder(t, tracer, "worker", 1024)
+	tracer.Enable()
+	allocs := testing.AllocsPerRun(100, func() {
+		r.End(id, r.Begin())
+	})
+	if allocs != 0 {
+		t.Errorf("Expected no allocations, found %f", allocs)
+	}
+}
+
+func BenchmarkDisabled(b *testing.B) {
+	tracer := New()
+	id := tracer.Name("span")
+	r, _ := tracer.NewRecorder("worker", 1024)
+	for i := 0; i < b.N; i++ {
+		r.End(id, r.Begin())
+	}
+}
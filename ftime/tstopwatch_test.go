@@ -0,0 +1,45 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package ftime
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStopwatch(t *testing.T) {
+	var s Stopwatch
+	if s.Stop() != 0 || s.Elapsed() != 0 {
+		t.Errorf("Expected stopped Stopwatch with nothing elapsed")
+	}
+	pause := DurationToTicks(time.Millisecond)
+	for i := 0; i < 3; i++ {
+		s.Start()
+		s.Start()
+		Pause(pause)
+		if !s.Running() || s.Elapsed() < pause*int64(i+1) {
+			t.Errorf("Expected running Stopwatch with at least %d elapsed, found %d", pause*int64(i+1), s.Elapsed())
+		}
+		if lap := s.Stop(); lap < pause {
+			t.Errorf("Expected lap of at least %d ticks, found %d", pause, lap)
+		}
+	}
+	if s.Laps() != 3 || s.Mean() < pause || s.Mean() != s.Elapsed()/3 {
+		t.Errorf("Expected 3 laps with mean of at least %d, found %d laps with mean %d", pause, s.Laps(), s.Mean())
+	}
+	if d := s.ElapsedDuration(); d < 2900*time.Microsecond {
+		t.Errorf("Expected at least 3ms elapsed, found %s", d)
+	}
+	// Time while stopped is not counted
+	elapsed := s.Elapsed()
+	Pause(pause)
+	if s.Elapsed() != elapsed {
+		t.Errorf("Elapsed time changed while stopped")
+	}
+	s.Reset()
+	if s.Elapsed() != 0 || s.Laps() != 0 || s.Running() {
+		t.Errorf("Expected Reset() to clear the Stopwatch")
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package wheel
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/fmstephe/flib/ftime"
+)
+
+// A timeout recording when it expired
+type timeout struct {
+	timer   Timer
+	id      int
+	expired int64
+}
+
+func newTimeouts(w *Wheel, n int, expired *[]int) []timeout {
+	timeouts := make([]timeout, n)
+	for i := range timeouts {
+		to := &timeouts[i]
+		to.id = i
+		to.expired = -1
+		to.timer.Init(func(*Timer) {
+			to.expired = w.Now()
+			*expired = append(*expired, to.id)
+		})
+	}
+	return timeouts
+}
+
+func newWheel(t *testing.T, now, resolution int64) *Wheel {
+	w, err := New(now, resolution)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return w
+}
+
+func TestExpiryOrder(t *testing.T) {
+	w := newWheel(t, 0, 1)
+	var expired []int
+	timeouts := newTimeouts(w, 5, &expired)
+	deadlines := []int64{50, 10, 5000, 70, 300000}
+	for i, d := range deadlines {
+		w.Schedule(&timeouts[i].timer, d)
+	}
+	if w.Len() != 5 {
+		t.Errorf("Expected 5 timers, found %d", w.Len())
+	}
+	for now := int64(1); now <= 300000; now++ {
+		w.Advance(now)
+	}
+	expected := []int{1, 0, 3, 2, 4}
+	for i := range expected {
+		if expired[i] != expected[i] {
+			t.Fatalf("Expected expiry order %v, found %v", expected, expired)
+		}
+	}
+	for i, d := range deadlines {
+		if timeouts[i].expired != d {
+			t.Errorf("Timer %d with deadline %d expired at %d", i, d, timeouts[i].expired)
+		}
+	}
+	if w.Len() != 0 {
+		t.Errorf("Expected empty wheel, found %d timers", w.Len())
+	}
+}
+
+func TestResolution(t *testing.T) {
+	w := newWheel(t, 1000, 100)
+	var expired []int
+	timeouts := newTimeouts(w, 1, &expired)
+	w.Schedule(&timeouts[0].timer, 1250)
+	// Never early, the deadline rounds up to 1300
+	if w.Advance(1299) != 0 {
+		t.Errorf("Timer expired before its deadline")
+	}
+	if w.Advance(1300) != 1 {
+		t.Errorf("Expected timer to expire at 1300")
+	}
+}
+
+func TestPastDeadline(t *testing.T) {
+	w := newWheel(t, 1000, 1)
+	var expired []int
+	timeouts := newTimeouts(w, 2, &expired)
+	w.Schedule(&timeouts[0].timer, 10)
+	w.Schedule(&timeouts[1].timer, 1000)
+	if n := w.Advance(1001); n != 2 {
+		t.Errorf("Expected 2 expired timers, found %d", n)
+	}
+}
+
+func TestCancelReschedule(t *testing.T) {
+	w := newWheel(t, 0, 1)
+	var expired []int
+	timeouts := newTimeouts(w, 3, &expired)
+	w.Schedule(&timeouts[0].timer, 100)
+	w.Schedule(&timeouts[1].timer, 100)
+	w.Schedule(&timeouts[2].timer, 100)
+	if !w.Cancel(&timeouts[1].timer) || timeouts[1].timer.Active() {
+		t.Errorf("Expected timer to be cancelled")
+	}
+	if w.Cancel(&timeouts[1].timer) {
+		t.Errorf("Expected second cancel to fail")
+	}
+	w.Reschedule(&timeouts[2].timer, 10000)
+	if timeouts[2].timer.Deadline() != 10000 || w.Len() != 2 {
+		t.Errorf("Expected rescheduled timer, deadline %d, %d timers", timeouts[2].timer.Deadline(), w.Len())
+	}
+	w.Advance(9999)
+	if len(expired) != 1 || expired[0] != 0 {
+		t.Errorf("Expected only timer 0 to expire, found %v", expired)
+	}
+	w.Advance(10000)
+	if len(expired) != 2 || expired[1] != 2 {
+		t.Errorf("Expected timer 2 to expire, found %v", expired)
+	}
+}
+
+// Expiry functions can reschedule their own timer, a heartbeat
+func TestRescheduleFromExpiry(t *testing.T) {
+	w := newWheel(t, 0, 1)
+	beats := 0
+	var heartbeat Timer
+	heartbeat.Init(func(t *Timer) {
+		beats++
+		w.Schedule(t, w.Now()+100)
+	})
+	w.Schedule(&heartbeat, 100)
+	w.Advance(1050)
+	if beats != 10 {
+		t.Errorf("Expected 10 heartbeats, found %d", beats)
+	}
+}
+
+func TestFarFuture(t *testing.T) {
+	w := newWheel(t, 0, 1)
+	var expired []int
+	timeouts := newTimeouts(w, 1, &expired)
+	deadline := int64(1) << 50
+	w.Schedule(&timeouts[0].timer, deadline)
+	w.Advance(deadline - 1)
+	if len(expired) != 0 {
+		t.Fatalf("Timer expired early")
+	}
+	w.Advance(deadline)
+	if timeouts[0].expired != deadline {
+		t.Errorf("Expected timer to expire at %d, expired at %d", deadline, timeouts[0].expired)
+	}
+}
+
+// Timers at random deadlines, advanced in random steps, must each expire
+// at the wheel tick of their deadline
+func TestRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	w := newWheel(t, 0, 1)
+	var expired []int
+	timeouts := newTimeouts(w, 10*1000, &expired)
+	deadlines := make([]int64, len(timeouts))
+	for i := range timeouts {
+		deadlines[i] = r.Int63n(1 << uint(r.Intn(30)+1))
+		w.Schedule(&timeouts[i].timer, deadlines[i])
+	}
+	cancelled := map[int]bool{}
+	for i := 0; i < 1000; i++ {
+		id := r.Intn(len(timeouts))
+		w.Cancel(&timeouts[id].timer)
+		cancelled[id] = true
+	}
+	for now := int64(1); now < 1<<31; now += r.Int63n(1 << uint(r.Intn(24))) {
+		w.Advance(now)
+	}
+	for i, to := range timeouts {
+		if cancelled[i] {
+			if to.expired != -1 {
+				t.Fatalf("Cancelled timer %d expired at %d", i, to.expired)
+			}
+			continue
+		}
+		// A deadline of 0 had already passed, so expires at the next tick
+		expected := deadlines[i]
+		if expected == 0 {
+			expected = 1
+		}
+		if to.expired != expected {
+			t.Fatalf("Timer %d with deadline %d expired at %d", i, deadlines[i], to.expired)
+		}
+	}
+	if w.Len() != 0 {
+		t.Errorf("Expected empty wheel, found %d timers", w.Len())
+	}
+}
+
+func TestNoAllocation(t *testing.T) {
+	w := newWheel(t, 0, 1)
+	var expired []int
+	timeouts := newTimeouts(w, 1000, &expired)
+	expired = make([]int, 0, 100*1000)
+	now := int64(0)
+	allocs := testing.AllocsPerRun(100, func() {
+		for i := range timeouts {
+			w.Schedule(&timeouts[i].timer, now+int64(i*i))
+		}
+		for i := 0; i < len(timeouts); i += 2 {
+			w.Cancel(&timeouts[i].timer)
+		}
+		now += 1000 * 1000
+		w.Advance(now)
+	})
+	if allocs != 0 {
+		t.Errorf("Expected no allocations, found %f", allocs)
+	}
+}
+
+func TestCounterWheel(t *testing.T) {
+	w, err := NewCounterWheel(time.Microsecond)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	fired := false
+	var timer Timer
+	timer.Init(func(*Timer) { fired = true })
+	w.ScheduleAfter(&timer, time.Millisecond)
+	start := time.Now()
+	for !fired {
+		w.Tick()
+	}
+	if elapsed := time.Since(start); elapsed < 900*time.Microsecond {
+		t.Errorf("Timer expired after %s, expected at least 1ms", elapsed)
+	}
+}
+
+func TestClockWheel(t *testing.T) {
+	c := ftime.NewFakeClock(time.Now(), 1)
+	// The fake clock starts at 0
+	c.Advance(1000)
+	w, err := NewClockWheel(c, time.Microsecond)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if w.Resolution() != 1000 {
+		t.Errorf("Expected resolution of 1000 ticks, found %d", w.Resolution())
+	}
+	fired := 0
+	var timer Timer
+	timer.Init(func(*Timer) { fired++ })
+	w.ScheduleAfter(&timer, 10*time.Microsecond)
+	c.AdvanceDuration(9 * time.Microsecond)
+	if w.Tick() != 0 {
+		t.Errorf("Timer expired early")
+	}
+	c.AdvanceDuration(time.Microsecond)
+	if w.Tick() != 1 || fired != 1 {
+		t.Errorf("Expected timer to expire after 10µs")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := New(0, 0); err == nil {
+		t.Errorf("Expected error for resolution of 0")
+	}
+	if _, err := New(-1, 1); err == nil {
+		t.Errorf("Expected error for negative now")
+	}
+}
+
+func BenchmarkScheduleCancel(b *testing.B) {
+	w, _ := New(0, 1)
+	timers := make([]Timer, 1024)
+	for i := 0; i < b.N; i++ {
+		t := &timers[i&1023]
+		w.Schedule(t, int64(i&0xFFFF))
+		if i&1 == 0 {
+			w.Cancel(t)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+// Package wheel provides a hierarchical timer wheel, for managing very
+// large numbers of short-lived timeouts from a single goroutine.
+//
+// Time is measured in whatever units the caller chooses, typically
+// ftime.Counter() ticks or nanoseconds, and only moves when the wheel is
+// advanced. Scheduling, rescheduling and cancelling a timer are O(1) and
+// never allocate, timers are owned by the caller and linked directly
+// into the wheel. A Wheel is not safe for use by multiple goroutines.
+package wheel
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fmstephe/flib/ftime"
+)
+
+const (
+	slotBits = 6
+	slots    = 1 << slotBits
+	slotMask = slots - 1
+	// With 8 levels of 64 slots, timers can be up to 2^48 wheel ticks
+	// ahead. Timers further ahead are placed in the last level and
+	// moved back down as the wheel turns.
+	levels   = 8
+	maxTicks = 1<<(slotBits*levels) - 1
+)
+
+// A Timer is scheduled on a Wheel, when its deadline passes it is
+// removed from the wheel and its expiry function is called. Timers are
+// usually embedded in, or allocated alongside, the value they time out
+// and reused for its lifetime.
+type Timer struct {
+	next     *Timer
+	prev     *Timer
+	wheel    *Wheel
+	deadline int64
+	level    uint8
+	slot     uint8
+	expire   func(*Timer)
+}
+
+// Sets the function called when t expires. Must not be called while t
+// is scheduled.
+func (t *Timer) Init(expire func(*Timer)) {
+	t.expire = expire
+}
+
+// Indicates whether t is scheduled on a wheel.
+func (t *Timer) Active() bool {
+	return t.wheel != nil
+}
+
+// The deadline t was most recently scheduled for.
+func (t *Timer) Deadline() int64 {
+	return t.deadline
+}
+
+type Wheel struct {
+	resolution int64
+	// The last wheel tick processed
+	tick     int64
+	now      int64
+	count    int
+	clock    ftime.Clock
+	occupied [levels]uint64
+	slots    [levels][slots]*Timer
+}
+
+// Creates a Wheel whose current time is now. Each slot of the wheel
+// covers resolution units of time, timers are expired no earlier than
+// their deadline but up to resolution late.
+func New(now, resolution int64) (*Wheel, error) {
+	if resolution < 1 {
+		return nil, errors.New(fmt.Sprintf("Resolution (%d) must be at least 1", resolution))
+	}
+	if now < 0 {
+		return nil, errors.New(fmt.Sprintf("Now (%d) must not be negative", now))
+	}
+	return &Wheel{resolution: resolution, tick: now / resolution, now: now}, nil
+}
+
+// Creates a Wheel measured in ftime.Counter() ticks, advanced by Tick().
+func NewCounterWheel(resolution time.Duration) (*Wheel, error) {
+	return NewClockWheel(ftime.RealClock{}, resolution)
+}
+
+// Creates a Wheel measured in the Counter() ticks of clock, advanced by
+// Tick().
+func NewClockWheel(clock ftime.Clock, resolution time.Duration) (*Wheel, error) {
+	ticks := durationToTicks(clock, resolution)
+	if ticks < 1 {
+		ticks = 1
+	}
+	w, err := New(clock.Counter(), ticks)
+	if err != nil {
+		return nil, err
+	}
+	w.clock = clock
+	return w, nil
+}
+
+func durationToTicks(clock ftime.Clock, d time.Duration) int64 {
+	return int64(float64(d) * clock.TicksPerNanosecond())
+}
+
+// The time the wheel was most recently advanced to. While timers are
+// being expired this is the time of the wheel tick being expired.
+func (w *Wheel) Now() int64 {
+	return w.now
+}
+
+func (w *Wheel) Resolution() int64 {
+	return w.resolution
+}
+
+// The number of scheduled timers.
+func (w *Wheel) Len() int {
+	return w.count
+}
+
+// Schedules t to expire at deadline. If t is already scheduled it is
+// rescheduled. A deadline which has already passed expires on the next
+// advance.
+func (w *Wheel) Schedule(t *Timer, deadline int64) {
+	if t.wheel != nil {
+		t.wheel.unlink(t)
+	}
+	t.deadline = deadline
+	t.wheel = w
+	w.count++
+	w.insert(t, w.tick+1)
+}
+
+// Schedules t to expire d after the wheel's current time, for wheels
+// created by NewCounterWheel or NewClockWheel.
+func (w *Wheel) ScheduleAfter(t *Timer, d time.Duration) {
+	w.Schedule(t, w.now+durationToTicks(w.clock, d))
+}
+
+// Moves t to a new deadline, whether or not it is scheduled.
+func (w *Wheel) Reschedule(t *Timer, deadline int64) {
+	w.Schedule(t, deadline)
+}
+
+// Removes t from the wheel. Returns false if t was not scheduled.
+func (w *Wheel) Cancel(t *Timer) bool {
+	if t.wheel != w {
+		return false
+	}
+	w.unlink(t)
+	return true
+}
+
+// Advances the wheel to now, expiring every timer whose deadline has
+// passed. Timers are expired in order of their wheel tick, the order of
+// timers within a tick is unspecified. Expiry functions may schedule
+// and cancel timers. Returns the number of timers expired.
+func (w *Wheel) Advance(now int64) int {
+	if now <= w.now {
+		return 0
+	}
+	target := now / w.resolution
+	expired := 0
+	for w.tick < target {
+		// If the lowest levels are empty nothing happens until the next
+		// cascade from the first occupied level
+		empty := 0
+		for empty < levels && w.occupied[empty] == 0 {
+			empty++
+		}
+		if empty == levels {
+			w.tick = target
+			break
+		}
+		next := w.tick + 1
+		if empty > 0 {
+			span := int64(1) << (slotBits * uint(empty))
+			next = (w.tick/span + 1) * span
+		}
+		if next > target {
+			w.tick = target
+			break
+		}
+		w.tick = next
+		// Expiry functions see the time of the tick being expired
+		if tickTime := next * w.resolution; tickTime > w.now {
+			w.now = tickTime
+		}
+		w.cascade(next)
+		expired += w.expire(next)
+	}
+	w.now = now
+	return expired
+}
+
+// Advances a wheel created by NewCounterWheel or NewClockWheel to its
+// clock's current Counter().
+func (w *Wheel) Tick() int {
+	return w.Advance(w.clock.Counter())
+}
+
+// Moves the timers of each level whose slot begins at tick down the
+// wheel.
+func (w *Wheel) cascade(tick int64) {
+	for level := 1; level < levels; level++ {
+		shift := slotBits * uint(level)
+		if tick&(1<<shift-1) != 0 {
+			return
+		}
+		slot := (tick >> shift) & slotMask
+		for t := w.slots[level][slot]; t != nil; t = w.slots[level][slot] {
+			w.remove(t)
+			w.insert(t, tick)
+		}
+	}
+}
+
+func (w *Wheel) expire(tick int64) int {
+	slot := tick & slotMask
+	expired := 0
+	for t := w.slots[0][slot]; t != nil; t = w.slots[0][slot] {
+		w.unlink(t)
+		expired++
+		if t.expire != nil {
+			t.expire(t)
+		}
+	}
+	return expired
+}
+
+// Places t in the slot which will be visited first at, or after, its
+// deadline. base is the first wheel tick still to be processed.
+func (w *Wheel) insert(t *Timer, base int64) {
+	// Round up, so a timer never expires before its deadline
+	deadlineTick := t.deadline / w.resolution
+	if deadlineTick*w.resolution < t.deadline {
+		deadlineTick++
+	}
+	delta := deadlineTick - base
+	if delta < 0 {
+		delta = 0
+		deadlineTick = base
+	}
+	if delta > maxTicks {
+		delta = maxTicks
+		deadlineTick = base + maxTicks
+	}
+	level := 0
+	for delta >= slots {
+		delta >>= slotBits
+		level++
+	}
+	slot := (deadlineTick >> (slotBits * uint(level))) & slotMask
+	t.level = uint8(level)
+	t.slot = uint8(slot)
+	t.prev = nil
+	t.next = w.slots[level][slot]
+	if t.next != nil {
+		t.next.prev = t
+	}
+	w.slots[level][slot] = t
+	w.occupied[level] |= 1 << uint(slot)
+}
+
+// Takes t out of its slot, it remains scheduled.
+func (w *Wheel) remove(t *Timer) {
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		w.slots[t.level][t.slot] = t.next
+		if t.next == nil {
+			w.occupied[t.level] &^= 1 << uint(t.slot)
+		}
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	}
+	t.next = nil
+	t.prev = nil
+}
+
+func (w *Wheel) unlink(t *Timer) {
+	w.remove(t)
+	t.wheel = nil
+	w.count--
+}
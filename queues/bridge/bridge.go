@@ -0,0 +1,110 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+// Package bridge forwards the messages in a ByteMsgQ to a ByteMsgQ on
+// another host. A Sender drains its queue and writes the messages in
+// batches to a TCP connection or as UDP datagrams. A Receiver reads the
+// batches and publishes the messages, in order, into its own queue.
+//
+// Every message carries a sequence number. Over TCP the Receiver
+// acknowledges each batch with the sequence number of the next message
+// it expects, and the Sender keeps each batch until it is acknowledged,
+// resending every unacknowledged batch after reconnecting, so no
+// messages are lost. The Receiver uses the sequence numbers to discard
+// the duplicates this produces, and to count messages lost in gaps,
+// which are expected with UDP.
+//
+// A batch is a header followed by its messages
+//
+//	[0:8]   session, chosen when the Sender is created
+//	[8:16]  sequence number of the first message
+//	[16:20] number of messages
+//	[20:24] number of bytes following the header
+//
+// each message being a 4 byte length followed by its bytes. An
+// acknowledgement is an 8 byte sequence number. All integers are
+// little-endian.
+package bridge
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	batchHeader = 24
+	msgHeader   = 4
+)
+
+type Config struct {
+	// The largest batch, in bytes, the Sender will write. A message
+	// which can't fit in a batch on its own is dropped. The Receiver
+	// rejects batches larger than this. For UDP it should fit in a
+	// datagram. Defaults to 64KB for TCP, 1400 bytes for UDP.
+	MaxBatch int
+	// The most bytes of batches a TCP Sender keeps until they are
+	// acknowledged. The Sender waits while the window is full. Defaults
+	// to 16 times MaxBatch.
+	Window int
+	// How long the Sender waits before reconnecting, and how long it
+	// waits for a connection. Defaults to 100ms.
+	RetryInterval time.Duration
+	// How long the Sender sleeps when its queue is empty, and the
+	// Receiver sleeps when its queue is full. Defaults to 50µs.
+	Idle time.Duration
+	// Called by the Receiver when messages are missing, with the
+	// sequence number expected and the sequence number received.
+	OnGap func(expected, received uint64)
+}
+
+func (c Config) withDefaults(network string) (Config, error) {
+	if network != "tcp" && network != "udp" {
+		return c, errors.New(fmt.Sprintf("Network (%s) must be tcp or udp", network))
+	}
+	if c.MaxBatch == 0 {
+		c.MaxBatch = 64 * 1024
+		if network == "udp" {
+			c.MaxBatch = 1400
+		}
+	}
+	if c.MaxBatch < batchHeader+msgHeader {
+		return c, errors.New(fmt.Sprintf("MaxBatch (%d) must be at least %d", c.MaxBatch, batchHeader+msgHeader))
+	}
+	if c.Window == 0 {
+		c.Window = 16 * c.MaxBatch
+	}
+	if c.Window < c.MaxBatch {
+		return c, errors.New(fmt.Sprintf("Window (%d) must be at least MaxBatch (%d)", c.Window, c.MaxBatch))
+	}
+	if c.RetryInterval == 0 {
+		c.RetryInterval = 100 * time.Millisecond
+	}
+	if c.Idle == 0 {
+		c.Idle = 50 * time.Microsecond
+	}
+	return c, nil
+}
+
+type header struct {
+	session uint64
+	seq     uint64
+	count   uint32
+	size    uint32
+}
+
+func (h *header) encode(b []byte) {
+	binary.LittleEndian.PutUint64(b, h.session)
+	binary.LittleEndian.PutUint64(b[8:], h.seq)
+	binary.LittleEndian.PutUint32(b[16:], h.count)
+	binary.LittleEndian.PutUint32(b[20:], h.size)
+}
+
+func (h *header) decode(b []byte) {
+	h.session = binary.LittleEndian.Uint64(b)
+	h.seq = binary.LittleEndian.Uint64(b[8:])
+	h.count = binary.LittleEndian.Uint32(b[16:])
+	h.size = binary.LittleEndian.Uint32(b[20:])
+}
@@ -0,0 +1,298 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package bridge
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fmstephe/flib/queues/spscq"
+)
+
+type ReceiverStats struct {
+	Messages    int64
+	Batches     int64
+	Connections int64
+	// Messages never received, found by gaps in the sequence numbers
+	Missing int64
+	// Messages received more than once, or out of order, and discarded
+	Duplicates int64
+	// Messages too large for the Receiver's queue, which are discarded
+	Dropped int64
+	// Malformed batches and failed reads
+	Errors int64
+}
+
+// A Receiver is the writer of its queue, nothing else may write to it.
+type Receiver struct {
+	q        *spscq.ByteMsgQ
+	network  string
+	config   Config
+	listener net.Listener
+	packet   net.PacketConn
+	conns    chan net.Conn
+	mu       sync.Mutex
+	conn     net.Conn
+	stopped  bool
+	wg       sync.WaitGroup
+	session  uint64
+	expected uint64
+	header   header
+	hbuf     []byte
+	abuf     []byte
+	body     []byte
+	stats    ReceiverStats
+}
+
+// Creates a Receiver, listening on addr, which publishes the messages
+// it receives into q. Network must be tcp or udp.
+func NewReceiver(q *spscq.ByteMsgQ, network, addr string, config Config) (*Receiver, error) {
+	config, err := config.withDefaults(network)
+	if err != nil {
+		return nil, err
+	}
+	r := &Receiver{
+		q:       q,
+		network: network,
+		config:  config,
+		hbuf:    make([]byte, batchHeader),
+		abuf:    make([]byte, 8),
+		body:    make([]byte, config.MaxBatch),
+	}
+	if network == "udp" {
+		r.packet, err = net.ListenPacket(network, addr)
+	} else {
+		r.listener, err = net.Listen(network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// The address the Receiver is listening on.
+func (r *Receiver) Addr() net.Addr {
+	if r.packet != nil {
+		return r.packet.LocalAddr()
+	}
+	return r.listener.Addr()
+}
+
+// Starts the goroutines which receive messages.
+func (r *Receiver) Start() {
+	if r.packet != nil {
+		r.wg.Add(1)
+		go r.runPackets()
+		return
+	}
+	r.conns = make(chan net.Conn)
+	r.wg.Add(2)
+	go r.accept()
+	go r.runConns()
+}
+
+// Stops receiving and closes the Receiver's connections.
+func (r *Receiver) Stop() {
+	r.mu.Lock()
+	r.stopped = true
+	if r.conn != nil {
+		r.conn.Close()
+	}
+	r.mu.Unlock()
+	if r.packet != nil {
+		r.packet.Close()
+	} else {
+		r.listener.Close()
+	}
+	r.wg.Wait()
+}
+
+// Safe to call from any goroutine.
+func (r *Receiver) Stats() ReceiverStats {
+	return ReceiverStats{
+		Messages:    atomic.LoadInt64(&r.stats.Messages),
+		Batches:     atomic.LoadInt64(&r.stats.Batches),
+		Connections: atomic.LoadInt64(&r.stats.Connections),
+		Missing:     atomic.LoadInt64(&r.stats.Missing),
+		Duplicates:  atomic.LoadInt64(&r.stats.Duplicates),
+		Dropped:     atomic.LoadInt64(&r.stats.Dropped),
+		Errors:      atomic.LoadInt64(&r.stats.Errors),
+	}
+}
+
+// Accepts TCP connections. A new connection replaces the current one,
+// which is closed, as a reconnecting Sender has abandoned it.
+func (r *Receiver) accept() {
+	defer r.wg.Done()
+	defer close(r.conns)
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		r.mu.Lock()
+		if r.stopped {
+			r.mu.Unlock()
+			conn.Close()
+			return
+		}
+		if r.conn != nil {
+			r.conn.Close()
+		}
+		r.conn = conn
+		r.mu.Unlock()
+		atomic.AddInt64(&r.stats.Connections, 1)
+		r.conns <- conn
+	}
+}
+
+func (r *Receiver) runConns() {
+	defer r.wg.Done()
+	for conn := range r.conns {
+		r.readConn(conn)
+	}
+}
+
+func (r *Receiver) readConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		if _, err := io.ReadFull(conn, r.hbuf); err != nil {
+			r.connError(err)
+			return
+		}
+		r.header.decode(r.hbuf)
+		if int(r.header.size) > len(r.body) {
+			atomic.AddInt64(&r.stats.Errors, 1)
+			return
+		}
+		if _, err := io.ReadFull(conn, r.body[:r.header.size]); err != nil {
+			r.connError(err)
+			return
+		}
+		if err := r.publish(r.body[:r.header.size]); err != nil {
+			atomic.AddInt64(&r.stats.Errors, 1)
+			return
+		}
+		binary.LittleEndian.PutUint64(r.abuf, r.expected)
+		if _, err := conn.Write(r.abuf); err != nil {
+			r.connError(err)
+			return
+		}
+	}
+}
+
+// Connections closed by the Sender, or by a replacing connection, are
+// not errors.
+func (r *Receiver) connError(err error) {
+	if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+		atomic.AddInt64(&r.stats.Errors, 1)
+	}
+}
+
+func (r *Receiver) runPackets() {
+	defer r.wg.Done()
+	// Large enough for any datagram
+	packet := make([]byte, 64*1024)
+	for {
+		n, _, err := r.packet.ReadFrom(packet)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			atomic.AddInt64(&r.stats.Errors, 1)
+			continue
+		}
+		if n < batchHeader {
+			atomic.AddInt64(&r.stats.Errors, 1)
+			continue
+		}
+		r.header.decode(packet)
+		if int(r.header.size) != n-batchHeader {
+			atomic.AddInt64(&r.stats.Errors, 1)
+			continue
+		}
+		if err := r.publish(packet[batchHeader:n]); err != nil {
+			atomic.AddInt64(&r.stats.Errors, 1)
+		}
+	}
+}
+
+// Publishes the messages in a batch's body into the queue, discarding
+// those already received.
+func (r *Receiver) publish(body []byte) error {
+	if r.header.session != r.session {
+		// A new Sender, its sequence numbers start again
+		r.session = r.header.session
+		r.expected = r.header.seq
+	}
+	if r.header.seq > r.expected {
+		missing := r.header.seq - r.expected
+		atomic.AddInt64(&r.stats.Missing, int64(missing))
+		if r.config.OnGap != nil {
+			r.config.OnGap(r.expected, r.header.seq)
+		}
+		r.expected = r.header.seq
+	}
+	seq := r.header.seq
+	i := 0
+	for n := uint32(0); n < r.header.count; n++ {
+		if len(body)-i < msgHeader {
+			return errors.New(fmt.Sprintf("Batch %d truncated at message %d", r.header.seq, n))
+		}
+		size := int(binary.LittleEndian.Uint32(body[i:]))
+		i += msgHeader
+		if len(body)-i < size {
+			return errors.New(fmt.Sprintf("Batch %d truncated at message %d", r.header.seq, n))
+		}
+		msg := body[i : i+size]
+		i += size
+		if seq < r.expected {
+			atomic.AddInt64(&r.stats.Duplicates, 1)
+		} else {
+			r.write(msg)
+			r.expected = seq + 1
+		}
+		seq++
+	}
+	atomic.AddInt64(&r.stats.Batches, 1)
+	return nil
+}
+
+// Writes msg to the queue, waiting while the queue is full. Messages
+// which can never fit are dropped.
+func (r *Receiver) write(msg []byte) {
+	if !r.fits(int64(len(msg))) {
+		atomic.AddInt64(&r.stats.Dropped, 1)
+		return
+	}
+	buf := r.q.AcquireWrite(int64(len(msg)))
+	for buf == nil {
+		if r.isStopped() {
+			return
+		}
+		time.Sleep(r.config.Idle)
+		buf = r.q.AcquireWrite(int64(len(msg)))
+	}
+	copy(buf, msg)
+	r.q.ReleaseWrite()
+	atomic.AddInt64(&r.stats.Messages, 1)
+}
+
+func (r *Receiver) fits(size int64) bool {
+	// Every message carries an 8 byte header in the queue
+	return size+8 <= r.q.Cap()
+}
+
+func (r *Receiver) isStopped() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stopped
+}
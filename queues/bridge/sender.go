@@ -0,0 +1,405 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package bridge
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/fmstephe/flib/queues/spscq"
+)
+
+type SenderStats struct {
+	Messages    int64
+	Batches     int64
+	Connections int64
+	// Messages too large to fit in a batch, which are discarded
+	Dropped int64
+	Errors  int64
+}
+
+// A Sender is the reader of its queue, nothing else may read from it.
+type Sender struct {
+	q       *spscq.ByteMsgQ
+	network string
+	addr    string
+	config  Config
+	conn    net.Conn
+	// Closed when the connection's acknowledgement reader stops
+	reading chan bool
+	header  header
+	batch   batch
+	// TCP batches kept until the Receiver acknowledges them. The first
+	// written have been written to the current connection, size is
+	// their total size in bytes.
+	unacked []batch
+	written int
+	size    int
+	spare   []batch
+	acked   uint64
+	buffers net.Buffers
+	packet  []byte
+	stop    chan bool
+	abandon chan bool
+	done    chan bool
+	// The queue's write sequence number when Stop was called
+	stopSeq int64
+	stats   SenderStats
+}
+
+type batch struct {
+	header []byte
+	body   []byte
+	// The sequence number following the batch's last message
+	end uint64
+}
+
+// Creates a Sender which forwards the messages in q to addr. Network
+// must be tcp or udp. No connection is made until Start is called.
+func NewSender(q *spscq.ByteMsgQ, network, addr string, config Config) (*Sender, error) {
+	config, err := config.withDefaults(network)
+	if err != nil {
+		return nil, err
+	}
+	s := &Sender{
+		q:       q,
+		network: network,
+		addr:    addr,
+		config:  config,
+		header:  header{session: uint64(time.Now().UnixNano())},
+	}
+	s.batch = s.newBatch()
+	return s, nil
+}
+
+// Starts a goroutine which sends the queue's messages.
+func (s *Sender) Start() {
+	s.stop = make(chan bool)
+	s.abandon = make(chan bool)
+	s.done = make(chan bool)
+	go s.run()
+}
+
+// Stops the Sender, after sending the messages queued before Stop was
+// called, messages queued afterwards are left in the queue. A TCP Sender
+// also waits for those messages to be acknowledged. If they are not
+// sent, and acknowledged, within the retry interval they are abandoned.
+// Does nothing if Start was not called.
+func (s *Sender) Stop() {
+	if s.stop == nil {
+		return
+	}
+	s.stopSeq = s.q.WriteSeq()
+	close(s.stop)
+	select {
+	case <-s.done:
+		return
+	case <-time.After(s.config.RetryInterval):
+	}
+	close(s.abandon)
+	<-s.done
+}
+
+// Safe to call from any goroutine.
+func (s *Sender) Stats() SenderStats {
+	return SenderStats{
+		Messages:    atomic.LoadInt64(&s.stats.Messages),
+		Batches:     atomic.LoadInt64(&s.stats.Batches),
+		Connections: atomic.LoadInt64(&s.stats.Connections),
+		Dropped:     atomic.LoadInt64(&s.stats.Dropped),
+		Errors:      atomic.LoadInt64(&s.stats.Errors),
+	}
+}
+
+func (s *Sender) run() {
+	defer close(s.done)
+	defer s.disconnect()
+	for {
+		stopping := s.stopping()
+		if s.fill(stopping) == 0 {
+			if stopping {
+				s.waitAcks()
+				return
+			}
+			if s.broken() && !s.reconnect() {
+				return
+			}
+			time.Sleep(s.config.Idle)
+			continue
+		}
+		if !s.send() {
+			return
+		}
+	}
+}
+
+func (s *Sender) stopping() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Copies messages from the queue into the next batch. Messages are
+// released as they are copied, so the batch can be resent after a
+// failure. Messages which can't fit in a batch are dropped, the
+// Receiver would reject them. Once stopping, messages queued after Stop
+// was called are not copied. Returns the number of messages in the
+// batch.
+func (s *Sender) fill(stopping bool) uint32 {
+	s.batch.body = s.batch.body[:0]
+	count := uint32(0)
+	for {
+		if stopping && s.q.ReadSeq() >= s.stopSeq {
+			break
+		}
+		msg, wrapped := s.q.AcquireReadV()
+		if msg == nil {
+			break
+		}
+		size := len(msg) + len(wrapped)
+		if batchHeader+msgHeader+size > s.config.MaxBatch {
+			s.q.ReleaseReadLazy()
+			atomic.AddInt64(&s.stats.Dropped, 1)
+			continue
+		}
+		if batchHeader+len(s.batch.body)+msgHeader+size > s.config.MaxBatch {
+			// Left acquired, it will be acquired again for the next batch
+			break
+		}
+		s.batch.body = binary.LittleEndian.AppendUint32(s.batch.body, uint32(size))
+		s.batch.body = append(s.batch.body, msg...)
+		s.batch.body = append(s.batch.body, wrapped...)
+		s.q.ReleaseReadLazy()
+		count++
+	}
+	s.header.count = count
+	s.header.size = uint32(len(s.batch.body))
+	return count
+}
+
+// Writes the current batch. Returns false if the Sender abandoned the
+// batch before it was written.
+func (s *Sender) send() bool {
+	s.header.encode(s.batch.header)
+	s.header.seq += uint64(s.header.count)
+	s.batch.end = s.header.seq
+	if s.network == "udp" {
+		return s.sendPacket()
+	}
+	return s.sendStream()
+}
+
+// Writes the current batch as a datagram. If it can't be written it is
+// dropped, the Receiver will see a gap.
+func (s *Sender) sendPacket() bool {
+	for s.conn == nil && !s.connect() {
+		if s.waitRetry() {
+			return false
+		}
+	}
+	// Each write is a datagram, so the batch must be written at once
+	s.packet = append(append(s.packet[:0], s.batch.header...), s.batch.body...)
+	if _, err := s.conn.Write(s.packet); err != nil {
+		atomic.AddInt64(&s.stats.Errors, 1)
+		return true
+	}
+	atomic.AddInt64(&s.stats.Messages, int64(s.header.count))
+	atomic.AddInt64(&s.stats.Batches, 1)
+	return true
+}
+
+// Writes the current batch to the TCP connection, keeping it until the
+// Receiver acknowledges it. Waits while the unacknowledged batches fill
+// the window. Returns false if the Sender abandoned it first.
+func (s *Sender) sendStream() bool {
+	for {
+		s.trim()
+		if s.size+batchHeader+len(s.batch.body) <= s.config.Window {
+			break
+		}
+		if s.broken() && !s.reconnect() {
+			return false
+		}
+		if s.waitIdle() {
+			return false
+		}
+	}
+	s.unacked = append(s.unacked, s.batch)
+	s.size += batchHeader + len(s.batch.body)
+	s.batch = s.newBatch()
+	if !s.flush() {
+		return false
+	}
+	atomic.AddInt64(&s.stats.Messages, int64(s.header.count))
+	atomic.AddInt64(&s.stats.Batches, 1)
+	return true
+}
+
+// Writes the unacknowledged batches not yet written to the current
+// connection, reconnecting until they are written. Returns false if the
+// Sender abandoned them first.
+func (s *Sender) flush() bool {
+	for {
+		if s.conn == nil && !s.connect() {
+			if s.waitRetry() {
+				return false
+			}
+			continue
+		}
+		err := s.write()
+		if err == nil {
+			return true
+		}
+		atomic.AddInt64(&s.stats.Errors, 1)
+		s.disconnect()
+		if s.waitRetry() {
+			return false
+		}
+	}
+}
+
+// Replaces a connection which has stopped acknowledging, writing every
+// unacknowledged batch to the new connection. Returns false if the
+// Sender abandoned them first.
+func (s *Sender) reconnect() bool {
+	s.disconnect()
+	return s.flush()
+}
+
+func (s *Sender) write() error {
+	// Written with writev. Buffers are consumed by WriteTo, so they are
+	// rebuilt each time.
+	s.buffers = s.buffers[:0]
+	for _, b := range s.unacked[s.written:] {
+		s.buffers = append(s.buffers, b.header, b.body)
+	}
+	buffers := s.buffers
+	if _, err := buffers.WriteTo(s.conn); err != nil {
+		return err
+	}
+	s.written = len(s.unacked)
+	return nil
+}
+
+// Waits for the Receiver to acknowledge every batch written, unless the
+// connection fails or the Sender abandons them.
+func (s *Sender) waitAcks() {
+	for {
+		s.trim()
+		if len(s.unacked) == 0 || s.broken() || s.waitIdle() {
+			return
+		}
+	}
+}
+
+// Discards the batches the Receiver has acknowledged, keeping their
+// buffers for reuse.
+func (s *Sender) trim() {
+	acked := atomic.LoadUint64(&s.acked)
+	n := 0
+	for n < len(s.unacked) && s.unacked[n].end <= acked {
+		s.size -= batchHeader + len(s.unacked[n].body)
+		s.spare = append(s.spare, s.unacked[n])
+		n++
+	}
+	s.unacked = s.unacked[:copy(s.unacked, s.unacked[n:])]
+	s.written = max(s.written-n, 0)
+}
+
+func (s *Sender) newBatch() batch {
+	if n := len(s.spare); n > 0 {
+		b := s.spare[n-1]
+		s.spare = s.spare[:n-1]
+		return b
+	}
+	return batch{
+		header: make([]byte, batchHeader),
+		body:   make([]byte, 0, s.config.MaxBatch),
+	}
+}
+
+// Reads the Receiver's acknowledgements, each the sequence number of the
+// next message it expects, until the connection fails.
+func (s *Sender) readAcks(conn net.Conn, reading chan bool) {
+	defer close(reading)
+	buf := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		atomic.StoreUint64(&s.acked, binary.LittleEndian.Uint64(buf))
+	}
+}
+
+// Returns true if the TCP connection has stopped acknowledging, because
+// it failed or the Receiver closed it.
+func (s *Sender) broken() bool {
+	if s.reading == nil {
+		return false
+	}
+	select {
+	case <-s.reading:
+		return true
+	default:
+		return false
+	}
+}
+
+// Sleeps for the idle interval. Returns true if the Sender has abandoned
+// its messages.
+func (s *Sender) waitIdle() bool {
+	select {
+	case <-s.abandon:
+		return true
+	case <-time.After(s.config.Idle):
+		return false
+	}
+}
+
+// Sleeps for the retry interval. Returns true if the Sender has
+// abandoned its messages.
+func (s *Sender) waitRetry() bool {
+	select {
+	case <-s.abandon:
+		return true
+	case <-time.After(s.config.RetryInterval):
+		return false
+	}
+}
+
+func (s *Sender) connect() bool {
+	conn, err := net.DialTimeout(s.network, s.addr, s.config.RetryInterval)
+	if err != nil {
+		atomic.AddInt64(&s.stats.Errors, 1)
+		return false
+	}
+	s.conn = conn
+	if s.network == "tcp" {
+		s.reading = make(chan bool)
+		go s.readAcks(conn, s.reading)
+	}
+	atomic.AddInt64(&s.stats.Connections, 1)
+	return true
+}
+
+// Waits for the acknowledgement reader to stop, so acknowledgements are
+// only read from the current connection.
+func (s *Sender) disconnect() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+		s.written = 0
+	}
+	if s.reading != nil {
+		<-s.reading
+		s.reading = nil
+	}
+}
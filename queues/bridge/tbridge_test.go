@@ -0,0 +1,483 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package bridge
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/fmstephe/flib/queues/spscq"
+)
+
+// Each message carries its id in the first 8 bytes, followed by a
+// pattern derived from the id.
+func fillMsg(msg []byte, id uint64) {
+	binary.LittleEndian.PutUint64(msg, id)
+	for i := 8; i < len(msg); i++ {
+		msg[i] = byte(id) + byte(i)
+	}
+}
+
+func checkMsg(t *testing.T, msg []byte) uint64 {
+	if len(msg) < 8 {
+		t.Fatalf("Message too short %v", msg)
+	}
+	id := binary.LittleEndian.Uint64(msg)
+	for i := 8; i < len(msg); i++ {
+		if msg[i] != byte(id)+byte(i) {
+			t.Fatalf("Message %d corrupted at byte %d", id, i)
+		}
+	}
+	return id
+}
+
+func newQ(t *testing.T, size int64) *spscq.ByteMsgQ {
+	q, err := spscq.NewByteMsgQ(size, 0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return q
+}
+
+// Writes msgs messages, of varying sizes, into q
+func produce(q *spscq.ByteMsgQ, msgs uint64, maxSize int64) {
+	for id := uint64(0); id < msgs; id++ {
+		size := 8 + int64(id)%(maxSize-7)
+		msg := q.AcquireWrite(size)
+		for msg == nil {
+			runtime.Gosched()
+			msg = q.AcquireWrite(size)
+		}
+		fillMsg(msg, id)
+		q.ReleaseWrite()
+	}
+}
+
+// Reads from q until the message with id last arrives, or timeout.
+// Returns the ids read.
+func consume(t *testing.T, q *spscq.ByteMsgQ, last uint64, timeout time.Duration) []uint64 {
+	var ids []uint64
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		msg := q.AcquireRead()
+		if msg == nil {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		id := checkMsg(t, msg)
+		q.ReleaseRead()
+		ids = append(ids, id)
+		if id == last {
+			return ids
+		}
+	}
+	t.Fatalf("Timed out after reading %d messages", len(ids))
+	return nil
+}
+
+func checkOrdered(t *testing.T, ids []uint64) {
+	for i := 1; i < len(ids); i++ {
+		if ids[i] <= ids[i-1] {
+			t.Fatalf("Message %d received after %d", ids[i], ids[i-1])
+		}
+	}
+}
+
+func TestTCP(t *testing.T) {
+	const msgs = 10 * 1000
+	in, out := newQ(t, 4096), newQ(t, 4096)
+	config := Config{MaxBatch: 1024}
+	r, err := NewReceiver(out, "tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	r.Start()
+	defer r.Stop()
+	s, err := NewSender(in, "tcp", r.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	s.Start()
+	go produce(in, msgs, 200)
+	ids := consume(t, out, msgs-1, 10*time.Second)
+	s.Stop()
+	if len(ids) != msgs {
+		t.Errorf("Expected %d messages, received %d", msgs, len(ids))
+	}
+	checkOrdered(t, ids)
+	sStats, rStats := s.Stats(), r.Stats()
+	if sStats.Messages != msgs || rStats.Messages != msgs {
+		t.Errorf("Expected %d messages sent and received, found %v %v", msgs, sStats, rStats)
+	}
+	if sStats.Batches < msgs*8/1024 {
+		t.Errorf("Expected batches of at most 1024 bytes, found %d batches", sStats.Batches)
+	}
+	if rStats.Missing != 0 || rStats.Duplicates != 0 || rStats.Errors != 0 {
+		t.Errorf("Unexpected receiver stats %v", rStats)
+	}
+}
+
+func TestUDP(t *testing.T) {
+	const msgs = 1000
+	in, out := newQ(t, 64*1024), newQ(t, 64*1024)
+	r, err := NewReceiver(out, "udp", "127.0.0.1:0", Config{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	r.Start()
+	defer r.Stop()
+	s, err := NewSender(in, "udp", r.Addr().String(), Config{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	produce(in, msgs, 100)
+	s.Start()
+	// Datagrams may be lost, so the last message may never arrive
+	deadline := time.Now().Add(10 * time.Second)
+	for s.Stats().Messages < msgs && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	s.Stop()
+	time.Sleep(50 * time.Millisecond)
+	var ids []uint64
+	for msg := out.AcquireRead(); msg != nil; msg = out.AcquireRead() {
+		ids = append(ids, checkMsg(t, msg))
+		out.ReleaseRead()
+	}
+	checkOrdered(t, ids)
+	rStats := r.Stats()
+	if rStats.Messages != int64(len(ids)) {
+		t.Errorf("Expected %d messages received, found %v", len(ids), rStats)
+	}
+	if len(ids) > 0 && int64(ids[len(ids)-1]+1) != rStats.Messages+rStats.Missing {
+		t.Errorf("Expected messages and missing to account for every id up to %d, found %v", ids[len(ids)-1], rStats)
+	}
+	if len(ids) == 0 {
+		t.Errorf("No messages received")
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	in, out := newQ(t, 4096), newQ(t, 4096)
+	config := Config{RetryInterval: 10 * time.Millisecond}
+	r, err := NewReceiver(out, "tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	r.Start()
+	defer r.Stop()
+	s, err := NewSender(in, "tcp", r.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	s.Start()
+	defer s.Stop()
+	produce(in, 10, 64)
+	ids := consume(t, out, 9, 10*time.Second)
+	// Break the connection, the Sender must reconnect to deliver more
+	r.mu.Lock()
+	r.conn.Close()
+	r.mu.Unlock()
+	id := uint64(10)
+	for ; s.Stats().Connections < 2; id++ {
+		msg := in.AcquireWrite(8)
+		if msg == nil {
+			t.Fatal("Sender is not draining its queue")
+		}
+		fillMsg(msg, id)
+		in.ReleaseWrite()
+		time.Sleep(time.Millisecond)
+	}
+	last := id
+	msg := in.AcquireWrite(8)
+	fillMsg(msg, last)
+	in.ReleaseWrite()
+	ids = append(ids, consume(t, out, last, 10*time.Second)...)
+	checkOrdered(t, ids)
+	rStats := r.Stats()
+	if rStats.Connections != 2 {
+		t.Errorf("Expected 2 connections, found %d", rStats.Connections)
+	}
+	if uint64(len(ids)) != last+1 || rStats.Missing != 0 {
+		t.Errorf("Expected all %d messages, found %d %v", last+1, len(ids), rStats)
+	}
+}
+
+// Batches written but never acknowledged are resent on the next
+// connection, those acknowledged are not.
+func TestResend(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer l.Close()
+	in := newQ(t, 4096)
+	s, err := NewSender(in, "tcp", l.Addr().String(), Config{RetryInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	s.Start()
+	defer s.Stop()
+	var h header
+	hbuf := make([]byte, batchHeader)
+	// Reads a batch, returning the sequence numbers of its messages
+	readBatch := func(conn net.Conn) (uint64, uint64) {
+		if _, err := io.ReadFull(conn, hbuf); err != nil {
+			t.Fatal(err.Error())
+		}
+		h.decode(hbuf)
+		if _, err := io.ReadFull(conn, make([]byte, h.size)); err != nil {
+			t.Fatal(err.Error())
+		}
+		return h.seq, h.seq + uint64(h.count)
+	}
+	write := func(id uint64) {
+		msg := in.AcquireWrite(8)
+		fillMsg(msg, id)
+		in.ReleaseWrite()
+	}
+	write(0)
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	first, end := readBatch(conn)
+	if first != 0 || end != 1 {
+		t.Fatalf("Expected message 0, found messages %d to %d", first, end)
+	}
+	ack := binary.LittleEndian.AppendUint64(nil, end)
+	if _, err := conn.Write(ack); err != nil {
+		t.Fatal(err.Error())
+	}
+	// Let the Sender read the acknowledgement
+	time.Sleep(50 * time.Millisecond)
+	write(1)
+	if seq, _ := readBatch(conn); seq != end {
+		t.Fatalf("Expected batch starting at %d, found %d", end, seq)
+	}
+	// Message 1 is never acknowledged
+	conn.Close()
+	conn, err = l.Accept()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer conn.Close()
+	if seq, resentEnd := readBatch(conn); seq != end || resentEnd != end+1 {
+		t.Fatalf("Expected message %d resent, found messages %d to %d", end, seq, resentEnd)
+	}
+}
+
+// A Receiver whose queue is full stops acknowledging, the Sender must
+// still stop once its window is full.
+func TestStopStalledReceiver(t *testing.T) {
+	in, out := newQ(t, 64*1024), newQ(t, 64)
+	config := Config{MaxBatch: 256}
+	r, err := NewReceiver(out, "tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	r.Start()
+	defer r.Stop()
+	s, err := NewSender(in, "tcp", r.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	s.Start()
+	produce(in, 2000, 16)
+	// Let the Sender fill its window
+	time.Sleep(100 * time.Millisecond)
+	stopped := make(chan bool)
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Sender did not stop")
+	}
+	if s.Stats().Messages >= 2000 {
+		t.Errorf("Expected the Sender to be waiting for acknowledgements, found %v", s.Stats())
+	}
+}
+
+// Stop sends the messages queued before it was called, and returns even
+// though the producer keeps writing.
+func TestStopWhileProducing(t *testing.T) {
+	in, out := newQ(t, 4096), newQ(t, 1024*1024)
+	config := Config{MaxBatch: 1024}
+	r, err := NewReceiver(out, "tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	r.Start()
+	defer r.Stop()
+	s, err := NewSender(in, "tcp", r.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	s.Start()
+	done := make(chan bool)
+	produced := make(chan bool)
+	go func() {
+		defer close(produced)
+		for id := uint64(0); ; id++ {
+			msg := in.AcquireWrite(8)
+			for msg == nil {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				runtime.Gosched()
+				msg = in.AcquireWrite(8)
+			}
+			fillMsg(msg, id)
+			in.ReleaseWrite()
+		}
+	}()
+	for r.Stats().Messages < 1000 {
+		time.Sleep(time.Millisecond)
+	}
+	queued := in.WriteSeq()
+	s.Stop()
+	close(done)
+	<-produced
+	if r.Stats().Messages < queued {
+		t.Errorf("Expected at least %d messages received after Stop, found %v", queued, r.Stats())
+	}
+	ids := consume(t, out, uint64(queued-1), time.Second)
+	checkOrdered(t, ids)
+	if uint64(len(ids)) != uint64(queued) {
+		t.Errorf("Expected %d messages, found %d", queued, len(ids))
+	}
+}
+
+func TestOversized(t *testing.T) {
+	in, out := newQ(t, 4096), newQ(t, 4096)
+	config := Config{MaxBatch: 256}
+	r, err := NewReceiver(out, "tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	r.Start()
+	defer r.Stop()
+	s, err := NewSender(in, "tcp", r.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// Message 1 can never fit in a batch
+	for id := uint64(0); id < 10; id++ {
+		size := int64(8)
+		if id == 1 {
+			size = 300
+		}
+		msg := in.AcquireWrite(size)
+		fillMsg(msg, id)
+		in.ReleaseWrite()
+	}
+	s.Start()
+	ids := consume(t, out, 9, 10*time.Second)
+	s.Stop()
+	expectedIDs := []uint64{0, 2, 3, 4, 5, 6, 7, 8, 9}
+	if len(ids) != len(expectedIDs) {
+		t.Fatalf("Expected messages %v, found %v", expectedIDs, ids)
+	}
+	for i := range ids {
+		if ids[i] != expectedIDs[i] {
+			t.Fatalf("Expected messages %v, found %v", expectedIDs, ids)
+		}
+	}
+	sStats, rStats := s.Stats(), r.Stats()
+	if sStats.Dropped != 1 || sStats.Connections != 1 {
+		t.Errorf("Expected 1 message dropped on 1 connection, found %v", sStats)
+	}
+	if rStats.Errors != 0 || rStats.Connections != 1 {
+		t.Errorf("Unexpected receiver stats %v", rStats)
+	}
+}
+
+func TestGaps(t *testing.T) {
+	r, err := NewReceiver(newQ(t, 1024), "udp", "127.0.0.1:0", Config{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer r.Stop()
+	var gaps [][2]uint64
+	r.config.OnGap = func(expected, received uint64) {
+		gaps = append(gaps, [2]uint64{expected, received})
+	}
+	batch := func(session, seq uint64, count uint32) {
+		body := []byte{}
+		for n := uint32(0); n < count; n++ {
+			msg := make([]byte, 8)
+			fillMsg(msg, seq+uint64(n))
+			body = binary.LittleEndian.AppendUint32(body, 8)
+			body = append(body, msg...)
+		}
+		r.header = header{session: session, seq: seq, count: count, size: uint32(len(body))}
+		if err := r.publish(body); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	batch(1, 0, 2)
+	batch(1, 5, 1)
+	batch(1, 4, 3)
+	batch(1, 0, 1)
+	// A new session starts again from its first sequence number
+	batch(2, 0, 1)
+	expected := ReceiverStats{Messages: 5, Batches: 5, Missing: 3, Duplicates: 3}
+	if r.Stats() != expected {
+		t.Errorf("Expected %v, found %v", expected, r.Stats())
+	}
+	if len(gaps) != 1 || gaps[0] != [2]uint64{2, 5} {
+		t.Errorf("Expected a single gap from 2 to 5, found %v", gaps)
+	}
+	var ids []uint64
+	for msg := r.q.AcquireRead(); msg != nil; msg = r.q.AcquireRead() {
+		ids = append(ids, checkMsg(t, msg))
+		r.q.ReleaseRead()
+	}
+	expectedIDs := []uint64{0, 1, 5, 6, 0}
+	if len(ids) != len(expectedIDs) {
+		t.Fatalf("Expected messages %v, found %v", expectedIDs, ids)
+	}
+	for i := range ids {
+		if ids[i] != expectedIDs[i] {
+			t.Fatalf("Expected messages %v, found %v", expectedIDs, ids)
+		}
+	}
+	truncated := binary.LittleEndian.AppendUint32(nil, 20)
+	truncated = append(truncated, make([]byte, 8)...)
+	r.header = header{session: 2, seq: 1, count: 1, size: uint32(len(truncated))}
+	if err := r.publish(truncated); err == nil {
+		t.Errorf("Expected error publishing truncated batch")
+	}
+}
+
+func TestStopWithoutStart(t *testing.T) {
+	s, err := NewSender(newQ(t, 1024), "tcp", "127.0.0.1:0", Config{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	s.Stop()
+}
+
+func TestConfigErrors(t *testing.T) {
+	q := newQ(t, 1024)
+	if _, err := NewSender(q, "unix", "/tmp/bridge", Config{}); err == nil {
+		t.Errorf("Expected error for unsupported network")
+	}
+	if _, err := NewReceiver(q, "tcp", "127.0.0.1:0", Config{MaxBatch: 8}); err == nil {
+		t.Errorf("Expected error for tiny MaxBatch")
+	}
+	if _, err := NewSender(q, "tcp", "127.0.0.1:0", Config{MaxBatch: 1024, Window: 512}); err == nil {
+		t.Errorf("Expected error for Window smaller than MaxBatch")
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"sync/atomic"
 
+	"github.com/fmstephe/flib/fmath"
 	"github.com/fmstephe/flib/fsync/fatomic"
 	"github.com/fmstephe/flib/fsync/padded"
 	"github.com/fmstephe/flib/ftime"
@@ -35,10 +36,16 @@ type ByteChunkQ struct {
 	_midbuffer  padded.CacheBuffer
 	ringBuffer  []byte
 	chunk       int64
+	mirrored    bool
 	_postbuffer padded.CacheBuffer
 }
 
 func NewByteChunkQ(size, pause, chunk int64) (*ByteChunkQ, error) {
+	return NewByteChunkQClock(size, pause, chunk, ftime.RealClock{})
+}
+
+// Like NewByteChunkQ, but failed reads and writes pause on clock.
+func NewByteChunkQClock(size, pause, chunk int64, clock ftime.Clock) (*ByteChunkQ, error) {
 	if size%chunk != 0 {
 		return nil, errors.New(fmt.Sprintf("Size must divide by chunk, (size) %d rem (chunk) %d = %d", size, chunk, size%chunk))
 	}
@@ -47,19 +54,57 @@ func NewByteChunkQ(size, pause, chunk int64) (*ByteChunkQ, error) {
 	if err != nil {
 		return nil, err // TODO is that the best error to return?
 	}
+	cq.clock = clock
 	return &ByteChunkQ{ringBuffer: ringBuffer, commonQ: cq, chunk: chunk}, nil
 }
 
+// Creates a ByteChunkQ whose ring buffer is mapped twice, back to back,
+// in virtual memory. Chunks are always contiguous, even across the end
+// of the ring buffer, so size need not divide by chunk. Size must be a
+// multiple of the page size. Only supported on Linux.
+//
+// The ring buffer is not managed by the garbage collector, Close must
+// be called when the queue is no longer used.
+func NewMirroredByteChunkQ(size, pause, chunk int64) (*ByteChunkQ, error) {
+	return NewMirroredByteChunkQClock(size, pause, chunk, ftime.RealClock{})
+}
+
+// Like NewMirroredByteChunkQ, but failed reads and writes pause on
+// clock.
+func NewMirroredByteChunkQClock(size, pause, chunk int64, clock ftime.Clock) (*ByteChunkQ, error) {
+	if chunk <= 0 || chunk > size {
+		return nil, errors.New(fmt.Sprintf("Chunk (%d) must be between 1 and size (%d)", chunk, size))
+	}
+	cq, err := newCommonQ(size, pause)
+	if err != nil {
+		return nil, err
+	}
+	cq.clock = clock
+	ringBuffer, err := mirroredByteSlice(size)
+	if err != nil {
+		return nil, err
+	}
+	return &ByteChunkQ{ringBuffer: ringBuffer, commonQ: cq, chunk: chunk, mirrored: true}, nil
+}
+
+// Releases the ring buffer of a mirrored queue. Does nothing for other
+// queues.
+func (q *ByteChunkQ) Close() error {
+	if !q.mirrored {
+		return nil
+	}
+	return unmapMirrored(q.ringBuffer)
+}
+
 func (q *ByteChunkQ) AcquireWrite() []byte {
 	chunk := q.chunk
 	write := q.write.Value
 	writeTo := write + chunk
 	readLimit := writeTo - q.size
 	if readLimit > q.readCache.Value {
-		q.readCache.Value = atomic.LoadInt64(&q.read.Value)
+		q.refreshReadCache()
 		if readLimit > q.readCache.Value {
-			q.failedWrites.Value++
-			ftime.Pause(q.pause)
+			q.failedWrite()
 			return nil
 		}
 	}
@@ -81,10 +126,9 @@ func (q *ByteChunkQ) AcquireRead() []byte {
 	read := q.read.Value
 	readTo := read + chunk
 	if readTo > q.writeCache.Value {
-		q.writeCache.Value = atomic.LoadInt64(&q.write.Value)
+		q.refreshWriteCache()
 		if readTo > q.writeCache.Value {
-			q.failedReads.Value++
-			ftime.Pause(q.pause)
+			q.failedRead()
 			return nil
 		}
 	}
@@ -93,6 +137,59 @@ func (q *ByteChunkQ) AcquireRead() []byte {
 	return q.ringBuffer[idx:nxt]
 }
 
+// Returns the next chunk to be read without consuming it, or nil if the
+// queue is empty. Must only be called by the reader. Unlike AcquireRead
+// an empty queue is not counted as a failed read.
+func (q *ByteChunkQ) Peek() []byte {
+	read := q.read.Value
+	if read+q.chunk > q.writeCache.Value {
+		q.refreshWriteCache()
+		if read+q.chunk > q.writeCache.Value {
+			return nil
+		}
+	}
+	idx := read & q.mask
+	return q.ringBuffer[idx : idx+q.chunk]
+}
+
+// Returns up to n of the next chunks to be read, as a single slice,
+// without consuming them. Unless the queue is mirrored the result is
+// truncated at the end of the ring buffer. Must only be called by the
+// reader. Unlike AcquireRead an empty queue is not counted as a failed
+// read.
+func (q *ByteChunkQ) PeekN(n int64) []byte {
+	read := q.read.Value
+	if read+n*q.chunk > q.writeCache.Value {
+		q.refreshWriteCache()
+		n = fmath.Min(n, (q.writeCache.Value-read)/q.chunk)
+	}
+	if n == 0 {
+		return nil
+	}
+	from := read & q.mask
+	to := from + n*q.chunk
+	if !q.mirrored {
+		to = fmath.Min(to, q.size)
+	}
+	return q.ringBuffer[from:to]
+}
+
+// Consumes every chunk in the queue, calling f for each. The chunks are
+// released together once f has been called for all of them. Chunks
+// written while Drain is running may not be consumed. Must only be
+// called by the reader. Returns the number of chunks consumed.
+func (q *ByteChunkQ) Drain(f func([]byte)) int64 {
+	q.refreshWriteCache()
+	read := q.read.Value
+	for ; read+q.chunk <= q.writeCache.Value; read += q.chunk {
+		idx := read & q.mask
+		f(q.ringBuffer[idx : idx+q.chunk])
+	}
+	count := (read - q.read.Value) / q.chunk
+	atomic.AddInt64(&q.read.Value, read-q.read.Value)
+	return count
+}
+
 func (q *ByteChunkQ) ReleaseRead() {
 	atomic.AddInt64(&q.read.Value, q.chunk)
 }
@@ -8,6 +8,7 @@ import (
 	"sync/atomic"
 	"unsafe"
 
+	"github.com/fmstephe/flib/fsync/fatomic"
 	"github.com/fmstephe/flib/fsync/padded"
 	"github.com/fmstephe/flib/ftime"
 )
@@ -27,6 +28,14 @@ type ByteMsgQueue interface {
 	ReleaseWriteLazy()
 }
 
+// A ByteMsgQueue which can also write, and read, messages which wrap
+// around the end of the ring buffer as two slices.
+type ByteMsgQueueV interface {
+	ByteMsgQueue
+	AcquireReadV() ([]byte, []byte)
+	AcquireWriteV(int64) ([]byte, []byte)
+}
+
 func NewByteMsgQueue(size, pause int64) (ByteMsgQueue, error) {
 	return NewByteMsgQ(size, pause)
 }
@@ -34,30 +43,98 @@ func NewByteMsgQueue(size, pause int64) (ByteMsgQueue, error) {
 type ByteMsgQ struct {
 	_prebuffer padded.CacheBuffer
 	commonQ
-	_midbuffer  padded.CacheBuffer
-	ringBuffer  []byte
+	_midbuffer padded.CacheBuffer
+	ringBuffer []byte
+	mirrored   bool
+	// The number of messages written and read, updated only by the
+	// writer and the reader respectively
+	writeMsgs padded.Int64
+	readMsgs  padded.Int64
+	// Reader only, holds a copy of a message which wraps around the end
+	// of the ring buffer for the reads which return a single slice
+	joined      []byte
 	_postbuffer padded.CacheBuffer
 }
 
 func NewByteMsgQ(size, pause int64) (*ByteMsgQ, error) {
+	return NewByteMsgQClock(size, pause, ftime.RealClock{})
+}
+
+// Like NewByteMsgQ, but failed reads and writes pause on clock.
+func NewByteMsgQClock(size, pause int64, clock ftime.Clock) (*ByteMsgQ, error) {
 	// TODO there is an effective minimum queue size - should be enforced
 	ringBuffer := padded.ByteSlice(int(size))
 	cq, err := newCommonQ(size, pause)
 	if err != nil {
 		return nil, err // TODO is that the best error to return?
 	}
+	cq.clock = clock
 	return &ByteMsgQ{ringBuffer: ringBuffer, commonQ: cq}, nil
 }
 
+// Creates a ByteMsgQ whose ring buffer is mapped twice, back to back, in
+// virtual memory. Messages are always written contiguously, even across
+// the end of the ring buffer, so no space is ever skipped and messages
+// never wrap. Size must be a multiple of the page size. Only supported
+// on Linux.
+//
+// The ring buffer is not managed by the garbage collector, Close must
+// be called when the queue is no longer used.
+func NewMirroredByteMsgQ(size, pause int64) (*ByteMsgQ, error) {
+	return NewMirroredByteMsgQClock(size, pause, ftime.RealClock{})
+}
+
+// Like NewMirroredByteMsgQ, but failed reads and writes pause on clock.
+func NewMirroredByteMsgQClock(size, pause int64, clock ftime.Clock) (*ByteMsgQ, error) {
+	cq, err := newCommonQ(size, pause)
+	if err != nil {
+		return nil, err
+	}
+	cq.clock = clock
+	ringBuffer, err := mirroredByteSlice(size)
+	if err != nil {
+		return nil, err
+	}
+	return &ByteMsgQ{ringBuffer: ringBuffer, commonQ: cq, mirrored: true}, nil
+}
+
+// Releases the ring buffer of a mirrored queue. Does nothing for other
+// queues.
+func (q *ByteMsgQ) Close() error {
+	if !q.mirrored {
+		return nil
+	}
+	return unmapMirrored(q.ringBuffer)
+}
+
+// Each message is written contiguously into the ring buffer, preceded
+// by an 8 byte header containing the size of the message including the
+// header. When a message would not fit in the space remaining at the
+// end of the ring buffer that space is skipped and the message is
+// written at the start. If the skipped space is large enough to hold a
+// header we write the negated size of the skipped space there. Smaller
+// remainders are always skipped, so they need no marker. Mirrored
+// queues never skip space, their ring buffer is long enough for any
+// message to be written contiguously at any position.
+//
+// The skipped space is released to the reader by itself, before the
+// message is written. This ensures that a message which is too large to
+// fit after the skipped space can't wait forever for the reader to free
+// space it needs.
+//
+// Messages larger than the ring buffer, including their header, can
+// never be written and always return nil.
 func (q *ByteMsgQ) AcquireWrite(bufferSize int64) []byte {
 	totalSize := bufferSize + headerSize
+	if totalSize > q.size {
+		q.failedWrite()
+		return nil
+	}
 	initFrom := q.write.Value & q.mask
-	rem := q.size - initFrom
-	if rem < totalSize {
-		if rem >= headerSize {
-			writeHeader(q.ringBuffer, initFrom, -rem)
+	if rem := q.size - initFrom; rem < totalSize && !q.mirrored {
+		if !q.skipWrite(initFrom, rem) {
+			return nil
 		}
-		atomic.AddInt64(&q.write.Value, rem)
 	}
 	from, to := q.msgWrite(totalSize)
 	if from == to {
@@ -67,56 +144,263 @@ func (q *ByteMsgQ) AcquireWrite(bufferSize int64) []byte {
 	return q.ringBuffer[from+headerSize : to]
 }
 
-func (q *ByteMsgQ) AcquireRead() []byte {
-	rem := q.size - (q.read.Value & q.mask)
-	if rem < headerSize {
-		atomic.AddInt64(&q.read.Value, rem)
-	}
-	initFrom := q.read.Value & q.mask
-	totalSize := readHeader(q.ringBuffer, initFrom)
-	if totalSize < 0 {
-		atomic.AddInt64(&q.read.Value, -totalSize)
-		initFrom = q.read.Value & q.mask
-		totalSize = readHeader(q.ringBuffer, initFrom)
-	}
-	from, to := q.msgRead(totalSize)
+// Like AcquireWrite, but the message may wrap around the end of the
+// ring buffer instead of skipping the space there. A message which
+// wraps around is returned as two slices, the end of the ring buffer
+// followed by the start. Otherwise the second slice is nil. This lets
+// writers use the whole ring buffer, at the cost of scatter/gather
+// copies.
+//
+// Messages written with AcquireWriteV may wrap around, and must be read
+// using AcquireReadV.
+func (q *ByteMsgQ) AcquireWriteV(bufferSize int64) ([]byte, []byte) {
+	totalSize := bufferSize + headerSize
+	if totalSize > q.size {
+		q.failedWrite()
+		return nil, nil
+	}
+	// The header itself is never split
+	initFrom := q.write.Value & q.mask
+	if rem := q.size - initFrom; rem < headerSize && !q.mirrored {
+		if !q.skipWrite(initFrom, rem) {
+			return nil, nil
+		}
+	}
+	from, to := q.msgWrite(totalSize)
 	if from == to {
-		return nil
+		return nil, nil
 	}
-	return q.ringBuffer[from+headerSize : to]
+	writeHeader(q.ringBuffer, from, totalSize)
+	return q.split(from+headerSize, to)
 }
 
-func (q *ByteMsgQ) msgWrite(bufferSize int64) (from int64, to int64) {
-	writeTo := q.write.Value + bufferSize
-	readLimit := writeTo - q.size
-	if readLimit > q.readCache.Value {
-		q.readCache.Value = atomic.LoadInt64(&q.read.Value)
-		if readLimit > q.readCache.Value {
-			q.failedWrites.Value++
-			ftime.Pause(q.pause)
-			return 0, 0
+// Skips the rem bytes remaining at the end of the ring buffer. Returns
+// false if the reader has not yet freed that space.
+func (q *ByteMsgQ) skipWrite(initFrom, rem int64) bool {
+	if !q.msgFits(rem) {
+		q.failedWrite()
+		return false
+	}
+	if rem >= headerSize {
+		writeHeader(q.ringBuffer, initFrom, -rem)
+	}
+	atomic.AddInt64(&q.write.Value, rem)
+	return true
+}
+
+// A message which wraps around the end of the ring buffer, which only
+// AcquireWriteV writes, is copied and the copy returned. AcquireReadV
+// avoids the copy.
+func (q *ByteMsgQ) AcquireRead() []byte {
+	return q.join(q.AcquireReadV())
+}
+
+// Returns msg, or if the message wraps around the end of the ring buffer
+// a copy of msg followed by wrapped. The copy is reused by the next call.
+func (q *ByteMsgQ) join(msg, wrapped []byte) []byte {
+	if wrapped == nil {
+		return msg
+	}
+	q.joined = append(append(q.joined[:0], msg...), wrapped...)
+	return q.joined
+}
+
+// Like AcquireRead, but returns messages which wrap around the end of
+// the ring buffer as two slices, the end of the ring buffer followed by
+// the start. Otherwise the second slice is nil.
+func (q *ByteMsgQ) AcquireReadV() ([]byte, []byte) {
+	msg, wrapped, size := q.nextMsg(q.read.Value, q.writeCache.Value)
+	if msg == nil {
+		q.refreshWriteCache()
+		msg, wrapped, size = q.nextMsg(q.read.Value, q.writeCache.Value)
+		if msg == nil {
+			if size != 0 {
+				// Release skipped space, the writer may be waiting for it
+				atomic.AddInt64(&q.read.Value, size)
+			}
+			q.failedRead()
+			return nil, nil
 		}
 	}
+	q.readSize.Value = size
+	return msg, wrapped
+}
+
+// Returns the next message to be read without consuming it, or nil if
+// the queue is empty. Must only be called by the reader. Unlike
+// AcquireRead an empty queue is not counted as a failed read. Like
+// AcquireRead a message which wraps around the end of the ring buffer is
+// copied.
+func (q *ByteMsgQ) Peek() []byte {
+	msg, wrapped, _ := q.nextMsg(q.read.Value, q.writeCache.Value)
+	if msg == nil {
+		q.refreshWriteCache()
+		msg, wrapped, _ = q.nextMsg(q.read.Value, q.writeCache.Value)
+	}
+	return q.join(msg, wrapped)
+}
+
+// Calls f for each of up to n of the next messages to be read, without
+// consuming them. Returns the number of messages f was called for. Must
+// only be called by the reader. Unlike AcquireRead an empty queue is not
+// counted as a failed read. Like AcquireRead a message which wraps
+// around the end of the ring buffer is copied.
+func (q *ByteMsgQ) PeekN(n int64, f func([]byte)) int64 {
+	count := int64(0)
+	read := q.read.Value
+	refreshed := false
+	for count < n {
+		msg, wrapped, size := q.nextMsg(read, q.writeCache.Value)
+		if msg == nil {
+			if refreshed {
+				break
+			}
+			q.refreshWriteCache()
+			refreshed = true
+			continue
+		}
+		read += size
+		f(q.join(msg, wrapped))
+		count++
+	}
+	return count
+}
+
+// Consumes every message in the queue, calling f for each. The messages
+// are released together once f has been called for all of them.
+// Messages written while Drain is running may not be consumed. Must
+// only be called by the reader. Returns the number of messages
+// consumed. Like AcquireRead a message which wraps around the end of the
+// ring buffer is copied.
+func (q *ByteMsgQ) Drain(f func([]byte)) int64 {
+	q.refreshWriteCache()
+	count := int64(0)
+	read := q.read.Value
+	for {
+		msg, wrapped, size := q.nextMsg(read, q.writeCache.Value)
+		read += size
+		if msg == nil {
+			break
+		}
+		f(q.join(msg, wrapped))
+		count++
+	}
+	q.readSize.Value = read - q.read.Value
+	fatomic.LazyStore(&q.readMsgs.Value, q.readMsgs.Value+count)
+	q.commonQ.ReleaseRead()
+	return count
+}
+
+// Like AcquireWrite, but also returns the sequence number of the
+// message. Messages are numbered from 0 in the order they are written.
+func (q *ByteMsgQ) AcquireWriteSeq(bufferSize int64) ([]byte, int64) {
+	return q.AcquireWrite(bufferSize), q.writeMsgs.Value
+}
+
+// Like AcquireRead, but also returns the sequence number of the message.
+func (q *ByteMsgQ) AcquireReadSeq() ([]byte, int64) {
+	return q.AcquireRead(), q.readMsgs.Value
+}
+
+// The message counts are updated before the cursors are released, so a
+// reader which has read a message always sees a WriteSeq beyond it.
+func (q *ByteMsgQ) ReleaseWrite() {
+	if q.writeSize.Value != 0 {
+		fatomic.LazyStore(&q.writeMsgs.Value, q.writeMsgs.Value+1)
+	}
+	q.commonQ.ReleaseWrite()
+}
+
+func (q *ByteMsgQ) ReleaseWriteLazy() {
+	if q.writeSize.Value != 0 {
+		fatomic.LazyStore(&q.writeMsgs.Value, q.writeMsgs.Value+1)
+	}
+	q.commonQ.ReleaseWriteLazy()
+}
+
+func (q *ByteMsgQ) ReleaseRead() {
+	if q.readSize.Value != 0 {
+		fatomic.LazyStore(&q.readMsgs.Value, q.readMsgs.Value+1)
+	}
+	q.commonQ.ReleaseRead()
+}
+
+func (q *ByteMsgQ) ReleaseReadLazy() {
+	if q.readSize.Value != 0 {
+		fatomic.LazyStore(&q.readMsgs.Value, q.readMsgs.Value+1)
+	}
+	q.commonQ.ReleaseReadLazy()
+}
+
+// The number of messages released by the writer, which is also the
+// sequence number of the next message to be written. Safe to call from
+// any goroutine.
+func (q *ByteMsgQ) WriteSeq() int64 {
+	return atomic.LoadInt64(&q.writeMsgs.Value)
+}
+
+// The number of messages released by the reader, which is also the
+// sequence number of the next message to be read. Safe to call from any
+// goroutine.
+func (q *ByteMsgQ) ReadSeq() int64 {
+	return atomic.LoadInt64(&q.readMsgs.Value)
+}
+
+// Reports whether bufferSize bytes can be written, refreshing the
+// cached read position if necessary.
+func (q *ByteMsgQ) msgFits(bufferSize int64) bool {
+	readLimit := q.write.Value + bufferSize - q.size
+	if readLimit > q.readCache.Value {
+		q.refreshReadCache()
+		return readLimit <= q.readCache.Value
+	}
+	return true
+}
+
+func (q *ByteMsgQ) msgWrite(bufferSize int64) (from int64, to int64) {
+	if !q.msgFits(bufferSize) {
+		q.failedWrite()
+		return 0, 0
+	}
 	from = q.write.Value & q.mask
 	to = from + bufferSize
 	q.writeSize.Value = bufferSize
 	return from, to
 }
 
-func (q *ByteMsgQ) msgRead(bufferSize int64) (from int64, to int64) {
-	readTo := q.read.Value + bufferSize
-	if readTo > q.writeCache.Value {
-		q.writeCache.Value = atomic.LoadInt64(&q.write.Value)
-		if readTo > q.writeCache.Value {
-			q.failedReads.Value++
-			ftime.Pause(q.pause)
-			return 0, 0
+// Returns the message found at read, skipping over any space at the end
+// of the ring buffer. Nothing at or beyond writeLimit is read. If the
+// message wraps around the end of the ring buffer it is returned in two
+// parts, msg and wrapped. The size returned includes the header and any
+// skipped space. If no message is available then msg is nil, and size
+// is the amount of skippable space found at read.
+func (q *ByteMsgQ) nextMsg(read, writeLimit int64) (msg, wrapped []byte, size int64) {
+	if read >= writeLimit {
+		return nil, nil, 0
+	}
+	from := read & q.mask
+	skip := int64(0)
+	if rem := q.size - from; rem < headerSize && !q.mirrored {
+		skip = rem
+	} else if header := readHeader(q.ringBuffer, from); header < 0 {
+		skip = -header
+	}
+	if skip != 0 {
+		if read+skip >= writeLimit {
+			return nil, nil, skip
 		}
+		from = 0
 	}
-	from = q.read.Value & q.mask
-	to = from + bufferSize
-	q.readSize.Value = bufferSize
-	return from, to
+	totalSize := readHeader(q.ringBuffer, from)
+	msg, wrapped = q.split(from+headerSize, from+totalSize)
+	return msg, wrapped, skip + totalSize
+}
+
+func (q *ByteMsgQ) split(from, to int64) ([]byte, []byte) {
+	if to <= q.size || q.mirrored {
+		return q.ringBuffer[from:to], nil
+	}
+	return q.ringBuffer[from:], q.ringBuffer[:to-q.size]
 }
 
 func writeHeader(buffer []byte, i, val int64) {
@@ -12,25 +12,29 @@ import (
 	"github.com/fmstephe/flib/fmath"
 	"github.com/fmstephe/flib/fsync/fatomic"
 	"github.com/fmstephe/flib/fsync/padded"
+	"github.com/fmstephe/flib/ftime"
 )
 
-const maxSize = 1 << 41
+const maxSize int64 = 1 << 41
 
 type commonQ struct {
 	// Readonly Fields
 	size  int64
 	mask  int64
 	pause int64
+	clock ftime.Clock
 	// Writer fields
 	write        padded.Int64
 	writeSize    padded.Int64
 	failedWrites padded.Int64
 	readCache    padded.Int64
+	writeHigh    padded.Int64
 	// Reader fields
 	read        padded.Int64
 	readSize    padded.Int64
 	failedReads padded.Int64
 	writeCache  padded.Int64
+	readHigh    padded.Int64
 }
 
 func newCommonQ(size, pause int64) (commonQ, error) {
@@ -44,6 +48,113 @@ func newCommonQ(size, pause int64) (commonQ, error) {
 	return commonQ{size: size, mask: size - 1, pause: pause}, nil
 }
 
+// Claims up to bufferSize slots for writing. The claim is truncated at
+// the end of the ring buffer, or by the number of free slots. If there
+// are no free slots then from == to.
+func (q *commonQ) acquireWrite(bufferSize int64) (from int64, to int64) {
+	from, claimed := q.acquireWriteV(bufferSize)
+	to = fmath.Min(from+claimed, q.size)
+	q.writeSize.Value = to - from
+	return from, to
+}
+
+// Claims up to bufferSize slots for writing. Unlike acquireWrite the
+// claim is not truncated at the end of the ring buffer, so from+claimed
+// may be greater than size.
+func (q *commonQ) acquireWriteV(bufferSize int64) (from int64, claimed int64) {
+	writeTo := q.write.Value + bufferSize
+	readLimit := writeTo - q.size
+	if readLimit > q.readCache.Value {
+		q.refreshReadCache()
+		if readLimit > q.readCache.Value {
+			bufferSize = q.readCache.Value + q.size - q.write.Value
+			if bufferSize == 0 {
+				q.failedWrite()
+			}
+		}
+	}
+	q.writeSize.Value = bufferSize
+	return q.write.Value & q.mask, bufferSize
+}
+
+// Claims up to bufferSize slots for reading. The claim is truncated at
+// the end of the ring buffer, or by the number of slots available to
+// be read. If nothing is available to be read then from == to.
+func (q *commonQ) acquireRead(bufferSize int64) (from int64, to int64) {
+	from, claimed := q.acquireReadV(bufferSize)
+	to = fmath.Min(from+claimed, q.size)
+	q.readSize.Value = to - from
+	return from, to
+}
+
+// Claims up to bufferSize slots for reading. Unlike acquireRead the
+// claim is not truncated at the end of the ring buffer, so from+claimed
+// may be greater than size.
+func (q *commonQ) acquireReadV(bufferSize int64) (from int64, claimed int64) {
+	readTo := q.read.Value + bufferSize
+	if readTo > q.writeCache.Value {
+		q.refreshWriteCache()
+		if readTo > q.writeCache.Value {
+			bufferSize = q.writeCache.Value - q.read.Value
+			if bufferSize == 0 {
+				q.failedRead()
+			}
+		}
+	}
+	q.readSize.Value = bufferSize
+	return q.read.Value & q.mask, bufferSize
+}
+
+// Pauses for ticks on clock, or with ftime.Pause() if clock is nil.
+func pauseOn(clock ftime.Clock, ticks int64) {
+	if clock == nil {
+		ftime.Pause(ticks)
+		return
+	}
+	clock.Pause(ticks)
+}
+
+// Called only by the writer. Reloads the shared read position and notes
+// how full the queue is at this moment.
+func (q *commonQ) refreshReadCache() {
+	q.readCache.Value = atomic.LoadInt64(&q.read.Value)
+	if used := q.write.Value - q.readCache.Value; used > q.writeHigh.Value {
+		atomic.StoreInt64(&q.writeHigh.Value, used)
+	}
+}
+
+// Called only by the reader. Reloads the shared write position and notes
+// how full the queue is at this moment.
+func (q *commonQ) refreshWriteCache() {
+	q.writeCache.Value = atomic.LoadInt64(&q.write.Value)
+	if used := q.writeCache.Value - q.read.Value; used > q.readHigh.Value {
+		atomic.StoreInt64(&q.readHigh.Value, used)
+	}
+}
+
+// Called only by the reader. Returns the number of entries available
+// to be read. Unlike acquireRead nothing is claimed and an empty queue
+// is not counted as a failed read.
+func (q *commonQ) readable() int64 {
+	if q.read.Value == q.writeCache.Value {
+		q.refreshWriteCache()
+	}
+	return q.writeCache.Value - q.read.Value
+}
+
+// The failure counters are written atomically so that they can be
+// read safely by goroutines other than the reader and writer. This
+// only costs us on the failure path.
+func (q *commonQ) failedWrite() {
+	atomic.AddInt64(&q.failedWrites.Value, 1)
+	pauseOn(q.clock, q.pause)
+}
+
+func (q *commonQ) failedRead() {
+	atomic.AddInt64(&q.failedReads.Value, 1)
+	pauseOn(q.clock, q.pause)
+}
+
 func (q *commonQ) ReleaseWrite() {
 	atomic.AddInt64(&q.write.Value, q.writeSize.Value)
 	q.writeSize.Value = 0
@@ -64,6 +175,40 @@ func (q *commonQ) ReleaseReadLazy() {
 	q.readSize.Value = 0
 }
 
+// Returns the number of unread entries in the queue. For ByteMsgQ this
+// is measured in bytes and includes message headers. Safe to call from
+// any goroutine, but the result may be out of date by the time it is
+// returned.
+func (q *commonQ) Len() int64 {
+	// read is loaded before write, so write >= read
+	read := atomic.LoadInt64(&q.read.Value)
+	write := atomic.LoadInt64(&q.write.Value)
+	return fmath.Min(write-read, q.size)
+}
+
+// The absolute position of the writer, the total number of entries
+// ever released by the writer. For ByteMsgQ and ByteChunkQ this is
+// measured in bytes. Safe to call from any goroutine.
+func (q *commonQ) WritePosition() int64 {
+	return atomic.LoadInt64(&q.write.Value)
+}
+
+// The absolute position of the reader, the total number of entries
+// ever released by the reader. Safe to call from any goroutine.
+func (q *commonQ) ReadPosition() int64 {
+	return atomic.LoadInt64(&q.read.Value)
+}
+
+func (q *commonQ) Cap() int64 {
+	return q.size
+}
+
+// Safe to call from any goroutine, but the result may be out of date by
+// the time it is returned.
+func (q *commonQ) IsEmpty() bool {
+	return q.Len() == 0
+}
+
 func (q *commonQ) FailedWrites() int64 {
 	return atomic.LoadInt64(&q.failedWrites.Value)
 }
@@ -0,0 +1,236 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package spscq
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/fmstephe/flib/fmath"
+	"github.com/fmstephe/flib/ftime"
+)
+
+const idSize = 8
+
+// A Duplex supports request/response calls between two goroutines, a
+// client and a server, using a ByteMsgQ in each direction. Each request
+// is assigned a correlation id, which the server attaches to the
+// response. The client may have many requests outstanding, and may poll
+// for their responses in any order.
+//
+// Call, Poll and Wait (and their Lazy variants) must only be called by
+// the client. Receive, ReleaseRequest and Respond (and their Lazy
+// variants) must only be called by the server.
+type Duplex struct {
+	requests  *ByteMsgQ
+	responses *ByteMsgQ
+	// Client fields
+	nextId  int64
+	mask    int64
+	pending []pendingResponse
+}
+
+// Request id uses the slot at id&mask, which is busy until its response
+// is polled for.
+type pendingResponse struct {
+	id    int64
+	busy  bool
+	ready bool
+	resp  []byte
+}
+
+// Size and pause configure both queues, as for NewByteMsgQ.
+// MaxOutstanding is the number of requests the client may have waiting
+// for a response and must be a power of two.
+func NewDuplex(size, pause, maxOutstanding int64) (*Duplex, error) {
+	return NewDuplexClock(size, pause, maxOutstanding, ftime.RealClock{})
+}
+
+// Like NewDuplex, but failed reads and writes, on both queues, pause on
+// clock.
+func NewDuplexClock(size, pause, maxOutstanding int64, clock ftime.Clock) (*Duplex, error) {
+	if !fmath.PowerOfTwo(maxOutstanding) {
+		return nil, errors.New(fmt.Sprintf("MaxOutstanding (%d) must be a power of two", maxOutstanding))
+	}
+	requests, err := NewByteMsgQClock(size, pause, clock)
+	if err != nil {
+		return nil, err
+	}
+	responses, err := NewByteMsgQClock(size, pause, clock)
+	if err != nil {
+		return nil, err
+	}
+	return &Duplex{
+		requests:  requests,
+		responses: responses,
+		mask:      maxOutstanding - 1,
+		pending:   make([]pendingResponse, maxOutstanding),
+	}, nil
+}
+
+// Sends a copy of req to the server. Returns the id used to poll for
+// the response. Returns false if the request made maxOutstanding calls
+// earlier is still outstanding, as it always is when the client has the
+// maximum number of requests outstanding, or the request queue is full.
+func (d *Duplex) Call(req []byte) (id int64, ok bool) {
+	if !d.writeRequest(req) {
+		return 0, false
+	}
+	d.requests.ReleaseWrite()
+	return d.issueId(), true
+}
+
+func (d *Duplex) CallLazy(req []byte) (id int64, ok bool) {
+	if !d.writeRequest(req) {
+		return 0, false
+	}
+	d.requests.ReleaseWriteLazy()
+	return d.issueId(), true
+}
+
+func (d *Duplex) writeRequest(req []byte) bool {
+	if d.pending[d.nextId&d.mask].busy {
+		return false
+	}
+	msg := d.requests.AcquireWrite(int64(idSize + len(req)))
+	if msg == nil {
+		return false
+	}
+	writeHeader(msg, 0, d.nextId)
+	copy(msg[idSize:], req)
+	return true
+}
+
+func (d *Duplex) issueId() int64 {
+	id := d.nextId
+	d.nextId++
+	p := &d.pending[id&d.mask]
+	p.id = id
+	p.busy = true
+	return id
+}
+
+// Returns the response to the request with id, if it has arrived.
+// Responses to other requests which arrive first are kept until they
+// are polled for. The response returned is only valid until the next
+// call to Call. Poll never succeeds for an id which is not outstanding.
+func (d *Duplex) Poll(id int64) ([]byte, bool) {
+	return d.poll(id, false)
+}
+
+func (d *Duplex) PollLazy(id int64) ([]byte, bool) {
+	return d.poll(id, true)
+}
+
+// Polls until the response to the request with id arrives. Each failed
+// read of the response queue pauses, as configured in NewDuplex. Id must
+// be outstanding, issued by Call and not yet returned by Poll or Wait,
+// otherwise Wait panics rather than waiting forever.
+func (d *Duplex) Wait(id int64) []byte {
+	d.mustBeOutstanding(id)
+	resp, ok := d.poll(id, false)
+	for !ok {
+		resp, ok = d.poll(id, false)
+	}
+	return resp
+}
+
+func (d *Duplex) WaitLazy(id int64) []byte {
+	d.mustBeOutstanding(id)
+	resp, ok := d.poll(id, true)
+	for !ok {
+		resp, ok = d.poll(id, true)
+	}
+	return resp
+}
+
+func (d *Duplex) mustBeOutstanding(id int64) {
+	p := &d.pending[id&d.mask]
+	if !(p.busy && p.id == id) {
+		panic(fmt.Sprintf("spscq: Duplex request %d is not outstanding", id))
+	}
+}
+
+func (d *Duplex) poll(id int64, lazy bool) ([]byte, bool) {
+	p := &d.pending[id&d.mask]
+	if !(p.ready && p.id == id) {
+		d.readResponses(lazy)
+		if !(p.ready && p.id == id) {
+			return nil, false
+		}
+	}
+	p.ready = false
+	p.busy = false
+	return p.resp, true
+}
+
+// Copies every available response into its pending slot. Only the
+// first read may fail, pausing as configured.
+func (d *Duplex) readResponses(lazy bool) {
+	msg := d.responses.AcquireRead()
+	for msg != nil {
+		id := readHeader(msg, 0)
+		p := &d.pending[id&d.mask]
+		p.id = id
+		p.ready = true
+		p.resp = append(p.resp[:0], msg[idSize:]...)
+		if lazy {
+			d.responses.ReleaseReadLazy()
+		} else {
+			d.responses.ReleaseRead()
+		}
+		if d.responses.Peek() == nil {
+			return
+		}
+		msg = d.responses.AcquireRead()
+	}
+}
+
+// Returns the next request from the client, or a nil req if there are
+// none. The request must be released with ReleaseRequest before the
+// next call to Receive.
+func (d *Duplex) Receive() (id int64, req []byte) {
+	msg := d.requests.AcquireRead()
+	if msg == nil {
+		return 0, nil
+	}
+	return readHeader(msg, 0), msg[idSize:]
+}
+
+func (d *Duplex) ReleaseRequest() {
+	d.requests.ReleaseRead()
+}
+
+func (d *Duplex) ReleaseRequestLazy() {
+	d.requests.ReleaseReadLazy()
+}
+
+// Sends a copy of resp to the client as the response to the request
+// with id. Returns false if the response queue is full.
+func (d *Duplex) Respond(id int64, resp []byte) bool {
+	if !d.writeResponse(id, resp) {
+		return false
+	}
+	d.responses.ReleaseWrite()
+	return true
+}
+
+func (d *Duplex) RespondLazy(id int64, resp []byte) bool {
+	if !d.writeResponse(id, resp) {
+		return false
+	}
+	d.responses.ReleaseWriteLazy()
+	return true
+}
+
+func (d *Duplex) writeResponse(id int64, resp []byte) bool {
+	msg := d.responses.AcquireWrite(int64(idSize + len(resp)))
+	if msg == nil {
+		return false
+	}
+	writeHeader(msg, 0, id)
+	copy(msg[idSize:], resp)
+	return true
+}
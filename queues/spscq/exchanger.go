@@ -0,0 +1,108 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package spscq
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/fmstephe/flib/ftime"
+)
+
+// An Exchanger passes preallocated objects from a producer to a
+// consumer, and returns them to the producer once the consumer is done
+// with them. Objects travel forward on one PointerQ and back on another,
+// the producer keeps returned objects on a free list. No allocations
+// are made after construction.
+//
+// Acquire and Send must only be called by the producer, Receive and
+// Return only by the consumer. Objects are not cleared when they are
+// returned.
+type Exchanger[T any] struct {
+	forward *PointerQ
+	back    *PointerQ
+	free    []*T
+}
+
+// Creates an Exchanger with size objects. Size must be a power of two.
+// Each queue can hold every object, so Send and Return only fail if an
+// object is sent or returned twice, or was not acquired from this
+// Exchanger. They panic if they fail, rather than losing the object.
+func NewExchanger[T any](size, pause int64) (*Exchanger[T], error) {
+	return NewExchangerClock[T](size, pause, ftime.RealClock{})
+}
+
+// Like NewExchanger, but failed reads and writes, on both queues, pause
+// on clock.
+func NewExchangerClock[T any](size, pause int64, clock ftime.Clock) (*Exchanger[T], error) {
+	forward, err := NewPointerQClock(size, pause, clock)
+	if err != nil {
+		return nil, err
+	}
+	back, err := NewPointerQClock(size, pause, clock)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]T, size)
+	free := make([]*T, size)
+	for i := range objects {
+		free[i] = &objects[i]
+	}
+	return &Exchanger[T]{forward: forward, back: back, free: free}, nil
+}
+
+// Returns an object for the producer to fill and Send. Returns nil if
+// every object has been sent and none have been returned yet.
+func (e *Exchanger[T]) Acquire() *T {
+	if len(e.free) == 0 {
+		returned := e.back.AcquireRead(e.back.size)
+		for _, ptr := range returned {
+			e.free = append(e.free, (*T)(ptr))
+		}
+		e.back.ReleaseRead()
+		if len(e.free) == 0 {
+			return nil
+		}
+	}
+	last := len(e.free) - 1
+	obj := e.free[last]
+	e.free[last] = nil
+	e.free = e.free[:last]
+	return obj
+}
+
+// Sends an object, acquired from this Exchanger, to the consumer.
+func (e *Exchanger[T]) Send(obj *T) {
+	mustWrite("Send", e.forward.WriteSingle(unsafe.Pointer(obj)))
+}
+
+func (e *Exchanger[T]) SendLazy(obj *T) {
+	mustWrite("SendLazy", e.forward.WriteSingleLazy(unsafe.Pointer(obj)))
+}
+
+// Returns the next object sent by the producer, or nil if there are
+// none.
+func (e *Exchanger[T]) Receive() *T {
+	return (*T)(e.forward.ReadSingle())
+}
+
+func (e *Exchanger[T]) ReceiveLazy() *T {
+	return (*T)(e.forward.ReadSingleLazy())
+}
+
+// Returns a received object to the producer.
+func (e *Exchanger[T]) Return(obj *T) {
+	mustWrite("Return", e.back.WriteSingle(unsafe.Pointer(obj)))
+}
+
+func (e *Exchanger[T]) ReturnLazy(obj *T) {
+	mustWrite("ReturnLazy", e.back.WriteSingleLazy(unsafe.Pointer(obj)))
+}
+
+func mustWrite(method string, written bool) {
+	if !written {
+		panic(fmt.Sprintf("spscq: Exchanger.%s found its queue full, an object was sent or returned twice, or not acquired from this Exchanger", method))
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package spscq
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// Returns a slice of 2*size bytes where the second half maps the same
+// physical memory as the first half. Any slice of up to size bytes taken
+// from the first half is therefore contiguous, even when it crosses the
+// end of the ring buffer. Size must be a multiple of the page size.
+//
+// The memory is not managed by the garbage collector and must be
+// released with unmapMirrored.
+func mirroredByteSlice(size int64) ([]byte, error) {
+	pageSize := int64(syscall.Getpagesize())
+	if size < pageSize || size%pageSize != 0 {
+		return nil, errors.New(fmt.Sprintf("Size (%d) must be a multiple of the page size (%d)", size, pageSize))
+	}
+	name := []byte("spscq\x00")
+	fd, _, errno := syscall.Syscall(sysMemfdCreate, uintptr(unsafe.Pointer(&name[0])), mfdCloexec, 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	defer syscall.Close(int(fd))
+	if err := syscall.Ftruncate(int(fd), size); err != nil {
+		return nil, err
+	}
+	// Reserve the address space for both halves, then map the file
+	// over each half
+	buf, err := syscall.Mmap(-1, 0, int(2*size), syscall.PROT_NONE, syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if err != nil {
+		return nil, err
+	}
+	base := uintptr(unsafe.Pointer(&buf[0]))
+	for _, addr := range []uintptr{base, base + uintptr(size)} {
+		_, _, errno := syscall.Syscall6(syscall.SYS_MMAP, addr, uintptr(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_FIXED, fd, 0)
+		if errno != 0 {
+			syscall.Munmap(buf)
+			return nil, errno
+		}
+	}
+	return buf, nil
+}
+
+func unmapMirrored(buf []byte) error {
+	return syscall.Munmap(buf)
+}
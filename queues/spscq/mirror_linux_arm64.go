@@ -0,0 +1,10 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package spscq
+
+const (
+	sysMemfdCreate = 279
+	mfdCloexec     = 1
+)
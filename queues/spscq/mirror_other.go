@@ -0,0 +1,20 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+//go:build !linux || !(amd64 || arm64)
+// +build !linux !amd64,!arm64
+
+package spscq
+
+import (
+	"errors"
+)
+
+func mirroredByteSlice(size int64) ([]byte, error) {
+	return nil, errors.New("Mirrored ring buffers are only supported on linux/amd64 and linux/arm64")
+}
+
+func unmapMirrored(buf []byte) error {
+	return nil
+}
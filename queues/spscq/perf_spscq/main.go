@@ -11,6 +11,7 @@ import (
 	"runtime/debug"
 	"unsafe"
 
+	"github.com/fmstephe/flib/fcpu"
 	"github.com/fmstephe/flib/fstrconv"
 )
 
@@ -42,6 +43,7 @@ func main() {
 	flag.Parse()
 	msgCount := (*millionMsgs) * 1e6
 	debug.SetGCPercent(-1)
+	print(fcpu.Detect().String())
 	if *bmqar || *all {
 		bmqarTest(msgCount, *pause, *msgSize, *qSize, *profile)
 	}
@@ -31,6 +31,14 @@ type PointerQueue interface {
 	WriteSingleLazy(unsafe.Pointer) bool
 }
 
+// A PointerQueue which can also acquire batches which wrap around the
+// end of the ring buffer, as two slices.
+type PointerQueueV interface {
+	PointerQueue
+	AcquireReadV(int64) ([]unsafe.Pointer, []unsafe.Pointer)
+	AcquireWriteV(int64) ([]unsafe.Pointer, []unsafe.Pointer)
+}
+
 func NewPointerQueue(size, pause int64) (PointerQueue, error) {
 	return NewPointerQ(size, pause)
 }
@@ -44,70 +52,164 @@ type PointerQ struct {
 }
 
 func NewPointerQ(size, pause int64) (*PointerQ, error) {
+	return NewPointerQClock(size, pause, ftime.RealClock{})
+}
+
+// Like NewPointerQ, but failed reads and writes pause on clock.
+func NewPointerQClock(size, pause int64, clock ftime.Clock) (*PointerQ, error) {
 	cq, err := newCommonQ(size, pause)
 	if err != nil {
 		return nil, err
 	}
+	cq.clock = clock
 	ringBuffer := padded.PointerSlice(int(size))
 	return &PointerQ{ringBuffer: ringBuffer, commonQ: cq}, nil
 }
 
+// Acquires up to bufferSize pointers for reading. The batch is truncated
+// at the end of the ring buffer, and by the number of pointers written,
+// so it may be shorter than bufferSize. Returns nil if the queue is
+// empty. Released using ReleaseRead or ReleaseReadLazy.
 func (q *PointerQ) AcquireRead(bufferSize int64) []unsafe.Pointer {
-	readTo := q.read.Value + bufferSize
-	if readTo > q.writeCache.Value {
-		q.writeCache.Value = atomic.LoadInt64(&q.write.Value)
-		if readTo > q.writeCache.Value {
-			bufferSize = q.writeCache.Value - q.read.Value
-			if bufferSize == 0 {
-				q.failedReads.Value++
-				ftime.Pause(q.pause)
-				return nil
-			}
-		}
+	from, to := q.acquireRead(bufferSize)
+	if from == to {
+		return nil
 	}
-	from := q.read.Value & q.mask
-	to := fmath.Min(from+bufferSize, q.size)
-	q.readSize.Value = to - from
 	return q.ringBuffer[from:to]
 }
 
+// Like AcquireRead, but the batch is not truncated at the end of the
+// ring buffer. A batch which wraps around is returned as two slices,
+// the end of the ring buffer followed by the start. Otherwise the
+// second slice is nil. Released using ReleaseRead or ReleaseReadLazy.
+func (q *PointerQ) AcquireReadV(bufferSize int64) ([]unsafe.Pointer, []unsafe.Pointer) {
+	return q.split(q.acquireReadV(bufferSize))
+}
+
 func (q *PointerQ) ReleaseRead() {
-	from := q.read.Value & q.mask
-	to := from + q.readSize.Value
-	for i := from; i < to; i++ {
-		q.ringBuffer[i] = nil
-	}
+	q.clearRead()
 	atomic.AddInt64(&q.read.Value, q.readSize.Value)
 	q.readSize.Value = 0
 }
 
 func (q *PointerQ) ReleaseReadLazy() {
-	from := q.read.Value & q.mask
+	q.clearRead()
+	fatomic.LazyStore(&q.read.Value, q.read.Value+q.readSize.Value)
+	q.readSize.Value = 0
+}
+
+// Clears the pointers being released so they can be garbage collected.
+func (q *PointerQ) clearRead() {
+	from := q.read.Value
 	to := from + q.readSize.Value
 	for i := from; i < to; i++ {
-		q.ringBuffer[i] = nil
+		q.ringBuffer[i&q.mask] = nil
 	}
-	fatomic.LazyStore(&q.read.Value, q.read.Value+q.readSize.Value)
-	q.readSize.Value = 0
 }
 
-func (q *PointerQ) AcquireWrite(bufferSize int64) []unsafe.Pointer {
-	writeTo := q.write.Value + bufferSize
-	readLimit := writeTo - q.size
-	if readLimit > q.readCache.Value {
-		q.readCache.Value = atomic.LoadInt64(&q.read.Value)
-		if readLimit > q.readCache.Value {
-			q.failedWrites.Value++
-			ftime.Pause(q.pause)
+// Returns the next pointer to be read without consuming it, or nil if
+// the queue is empty. Must only be called by the reader. Unlike
+// ReadSingle an empty queue is not counted as a failed read.
+func (q *PointerQ) Peek() unsafe.Pointer {
+	read := q.read.Value
+	if read == q.writeCache.Value {
+		q.refreshWriteCache()
+		if read == q.writeCache.Value {
 			return nil
 		}
 	}
-	from := q.write.Value & q.mask
-	to := fmath.Min(from+bufferSize, q.size)
-	q.writeSize.Value = to - from
+	return q.ringBuffer[read&q.mask]
+}
+
+// Returns up to n of the next pointers to be read without consuming
+// them. Like AcquireRead the result is truncated at the end of the ring
+// buffer. Must only be called by the reader. Unlike AcquireRead an empty
+// queue is not counted as a failed read.
+func (q *PointerQ) PeekN(n int64) []unsafe.Pointer {
+	read := q.read.Value
+	if read+n > q.writeCache.Value {
+		q.refreshWriteCache()
+		n = fmath.Min(n, q.writeCache.Value-read)
+	}
+	if n == 0 {
+		return nil
+	}
+	from := read & q.mask
+	to := fmath.Min(from+n, q.size)
+	return q.ringBuffer[from:to]
+}
+
+// Consumes every pointer in the queue, calling f for each. Pointers
+// written while Drain is running may not be consumed. Must only be
+// called by the reader. Returns the number of pointers consumed.
+func (q *PointerQ) Drain(f func(unsafe.Pointer)) int64 {
+	q.refreshWriteCache()
+	count := q.writeCache.Value - q.read.Value
+	for q.read.Value < q.writeCache.Value {
+		for _, ptr := range q.AcquireRead(q.writeCache.Value - q.read.Value) {
+			f(ptr)
+		}
+		q.ReleaseRead()
+	}
+	return count
+}
+
+// Acquires up to bufferSize slots for writing. The batch is truncated at
+// the end of the ring buffer, and by the number of free slots, so it may
+// be shorter than bufferSize. Returns nil only if the queue is full.
+// Released using ReleaseWrite or ReleaseWriteLazy.
+func (q *PointerQ) AcquireWrite(bufferSize int64) []unsafe.Pointer {
+	from, to := q.acquireWrite(bufferSize)
+	if from == to {
+		return nil
+	}
 	return q.ringBuffer[from:to]
 }
 
+// Like AcquireWrite, but the batch is not truncated at the end of the
+// ring buffer. A batch which wraps around is returned as two slices,
+// the end of the ring buffer followed by the start. Otherwise the
+// second slice is nil. Released using ReleaseWrite or ReleaseWriteLazy.
+func (q *PointerQ) AcquireWriteV(bufferSize int64) ([]unsafe.Pointer, []unsafe.Pointer) {
+	return q.split(q.acquireWriteV(bufferSize))
+}
+
+func (q *PointerQ) split(from, size int64) ([]unsafe.Pointer, []unsafe.Pointer) {
+	if size == 0 {
+		return nil, nil
+	}
+	to := from + size
+	if to <= q.size {
+		return q.ringBuffer[from:to], nil
+	}
+	return q.ringBuffer[from:], q.ringBuffer[:to-q.size]
+}
+
+// Like AcquireWrite, but also returns the sequence number of the first
+// pointer in the batch. Pointers are numbered from 0 in the order they
+// are written.
+func (q *PointerQ) AcquireWriteSeq(bufferSize int64) ([]unsafe.Pointer, int64) {
+	return q.AcquireWrite(bufferSize), q.write.Value
+}
+
+// Like AcquireRead, but also returns the sequence number of the first
+// pointer in the batch.
+func (q *PointerQ) AcquireReadSeq(bufferSize int64) ([]unsafe.Pointer, int64) {
+	return q.AcquireRead(bufferSize), q.read.Value
+}
+
+// The sequence number of the next pointer to be written. Safe to call
+// from any goroutine.
+func (q *PointerQ) WriteSeq() int64 {
+	return q.WritePosition()
+}
+
+// The sequence number of the next pointer to be read. Safe to call from
+// any goroutine.
+func (q *PointerQ) ReadSeq() int64 {
+	return q.ReadPosition()
+}
+
 func (q *PointerQ) ReleaseWrite() {
 	atomic.AddInt64(&q.write.Value, q.writeSize.Value)
 	q.writeSize.Value = 0
@@ -145,10 +247,9 @@ func (q *PointerQ) writeSingle(val unsafe.Pointer) bool {
 	write := q.write.Value
 	readLimit := write - q.size
 	if readLimit == q.readCache.Value {
-		q.readCache.Value = atomic.LoadInt64(&q.read.Value)
+		q.refreshReadCache()
 		if readLimit == q.readCache.Value {
-			q.failedWrites.Value++
-			ftime.Pause(q.pause)
+			q.failedWrite()
 			return false
 		}
 	}
@@ -183,10 +284,9 @@ func (q *PointerQ) ReadSingleLazy() unsafe.Pointer {
 func (q *PointerQ) readSingle() unsafe.Pointer {
 	read := q.read.Value
 	if read == q.writeCache.Value {
-		q.writeCache.Value = atomic.LoadInt64(&q.write.Value)
+		q.refreshWriteCache()
 		if read == q.writeCache.Value {
-			q.failedReads.Value++
-			ftime.Pause(q.pause)
+			q.failedRead()
 			return nil
 		}
 	}
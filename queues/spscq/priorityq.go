@@ -0,0 +1,263 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package spscq
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/fmstephe/flib/fsync/padded"
+	"github.com/fmstephe/flib/ftime"
+)
+
+// A PriorityPointerQ is a set of PointerQ lanes, lane 0 having the
+// highest priority. Pointers are written directly to a lane, each lane
+// may have its own writer. The single reader reads from the lanes as if
+// they were one queue, each batch coming from a single lane.
+//
+// By default reads always drain higher priority lanes first. Lower
+// priority lanes can be protected from starvation by weights, see
+// NewWeightedPriorityPointerQ, or by aging, see
+// NewAgingPriorityPointerQ.
+type PriorityPointerQ struct {
+	_prebuffer padded.CacheBuffer
+	// Readonly Fields
+	lanes   []*PointerQ
+	weights []int64
+	maxAge  int64
+	pause   int64
+	clock   ftime.Clock
+	// Reader fields
+	_midbuffer  padded.CacheBuffer
+	current     int
+	credits     []int64
+	waited      []int64
+	ready       []bool
+	failedReads padded.Int64
+	_postbuffer padded.CacheBuffer
+}
+
+// Creates a PriorityPointerQ with lanes lanes, each of size pointers.
+// Reads are strictly by priority, lower priority lanes are only read
+// when every higher priority lane is empty.
+func NewPriorityPointerQ(size, pause int64, lanes int) (*PriorityPointerQ, error) {
+	return NewPriorityPointerQClock(size, pause, lanes, ftime.RealClock{})
+}
+
+// Like NewPriorityPointerQ, but failed reads and writes, on every lane,
+// pause on clock.
+func NewPriorityPointerQClock(size, pause int64, lanes int, clock ftime.Clock) (*PriorityPointerQ, error) {
+	if lanes < 1 {
+		return nil, errors.New(fmt.Sprintf("Lanes (%d) must be at least 1", lanes))
+	}
+	q := &PriorityPointerQ{
+		lanes: make([]*PointerQ, lanes),
+		pause: pause,
+		clock: clock,
+		ready: make([]bool, lanes),
+	}
+	for i := range q.lanes {
+		lane, err := NewPointerQClock(size, pause, clock)
+		if err != nil {
+			return nil, err
+		}
+		q.lanes[i] = lane
+	}
+	return q, nil
+}
+
+// Creates a PriorityPointerQ with a lane for each weight. Reads proceed
+// in rounds, in each round lane i is read from at most weights[i] times
+// while any lane with remaining reads has pointers available. Within a
+// round higher priority lanes are still read first. When no lane with
+// remaining reads has pointers available a new round begins.
+func NewWeightedPriorityPointerQ(size, pause int64, weights []int64) (*PriorityPointerQ, error) {
+	return NewWeightedPriorityPointerQClock(size, pause, weights, ftime.RealClock{})
+}
+
+// Like NewWeightedPriorityPointerQ, but failed reads and writes, on every
+// lane, pause on clock.
+func NewWeightedPriorityPointerQClock(size, pause int64, weights []int64, clock ftime.Clock) (*PriorityPointerQ, error) {
+	for i, w := range weights {
+		if w < 1 {
+			return nil, errors.New(fmt.Sprintf("Weight (%d) of lane %d must be at least 1", w, i))
+		}
+	}
+	q, err := NewPriorityPointerQClock(size, pause, len(weights), clock)
+	if err != nil {
+		return nil, err
+	}
+	q.weights = append([]int64(nil), weights...)
+	q.credits = append([]int64(nil), weights...)
+	return q, nil
+}
+
+// Creates a PriorityPointerQ where a lane which has been passed over
+// maxAge times, while it had pointers available, is read from ahead of
+// every other lane. If several lanes have aged the one passed over most
+// often is read first.
+func NewAgingPriorityPointerQ(size, pause int64, lanes int, maxAge int64) (*PriorityPointerQ, error) {
+	return NewAgingPriorityPointerQClock(size, pause, lanes, maxAge, ftime.RealClock{})
+}
+
+// Like NewAgingPriorityPointerQ, but failed reads and writes, on every
+// lane, pause on clock.
+func NewAgingPriorityPointerQClock(size, pause int64, lanes int, maxAge int64, clock ftime.Clock) (*PriorityPointerQ, error) {
+	if maxAge < 1 {
+		return nil, errors.New(fmt.Sprintf("maxAge (%d) must be at least 1", maxAge))
+	}
+	q, err := NewPriorityPointerQClock(size, pause, lanes, clock)
+	if err != nil {
+		return nil, err
+	}
+	q.maxAge = maxAge
+	q.waited = make([]int64, lanes)
+	return q, nil
+}
+
+// Returns the lane for the writer to write to.
+func (q *PriorityPointerQ) Lane(lane int) *PointerQ {
+	return q.lanes[lane]
+}
+
+func (q *PriorityPointerQ) Lanes() int {
+	return len(q.lanes)
+}
+
+// The lane of the most recent read.
+func (q *PriorityPointerQ) LastLane() int {
+	return q.current
+}
+
+// Chooses the lane to read from next, returns -1 if every lane is
+// empty.
+func (q *PriorityPointerQ) selectLane() int {
+	chosen := -1
+	for i, lane := range q.lanes {
+		q.ready[i] = lane.readable() > 0
+		if q.ready[i] && chosen < 0 {
+			chosen = i
+		}
+	}
+	if chosen < 0 {
+		return -1
+	}
+	switch {
+	case q.weights != nil:
+		for i := range q.lanes {
+			if q.ready[i] && q.credits[i] > 0 {
+				q.credits[i]--
+				return i
+			}
+		}
+		// Every ready lane has used its reads, a new round begins
+		copy(q.credits, q.weights)
+		q.credits[chosen]--
+	case q.maxAge > 0:
+		oldest := -1
+		for i := range q.lanes {
+			if q.ready[i] && q.waited[i] >= q.maxAge && (oldest < 0 || q.waited[i] > q.waited[oldest]) {
+				oldest = i
+			}
+		}
+		if oldest >= 0 {
+			chosen = oldest
+		}
+		for i := range q.lanes {
+			if q.ready[i] {
+				q.waited[i]++
+			}
+		}
+		q.waited[chosen] = 0
+	}
+	return chosen
+}
+
+func (q *PriorityPointerQ) failedRead() {
+	atomic.AddInt64(&q.failedReads.Value, 1)
+	pauseOn(q.clock, q.pause)
+}
+
+// Reads up to bufferSize pointers from a single lane.
+func (q *PriorityPointerQ) AcquireRead(bufferSize int64) []unsafe.Pointer {
+	lane := q.selectLane()
+	if lane < 0 {
+		q.failedRead()
+		return nil
+	}
+	q.current = lane
+	return q.lanes[lane].AcquireRead(bufferSize)
+}
+
+func (q *PriorityPointerQ) AcquireReadV(bufferSize int64) ([]unsafe.Pointer, []unsafe.Pointer) {
+	lane := q.selectLane()
+	if lane < 0 {
+		q.failedRead()
+		return nil, nil
+	}
+	q.current = lane
+	return q.lanes[lane].AcquireReadV(bufferSize)
+}
+
+func (q *PriorityPointerQ) ReleaseRead() {
+	q.lanes[q.current].ReleaseRead()
+}
+
+func (q *PriorityPointerQ) ReleaseReadLazy() {
+	q.lanes[q.current].ReleaseReadLazy()
+}
+
+func (q *PriorityPointerQ) ReadSingle() unsafe.Pointer {
+	lane := q.selectLane()
+	if lane < 0 {
+		q.failedRead()
+		return nil
+	}
+	q.current = lane
+	return q.lanes[lane].ReadSingle()
+}
+
+func (q *PriorityPointerQ) ReadSingleLazy() unsafe.Pointer {
+	lane := q.selectLane()
+	if lane < 0 {
+		q.failedRead()
+		return nil
+	}
+	q.current = lane
+	return q.lanes[lane].ReadSingleLazy()
+}
+
+func (q *PriorityPointerQ) ReadSingleBlocking() unsafe.Pointer {
+	val := q.ReadSingle()
+	for val == nil {
+		val = q.ReadSingle()
+	}
+	return val
+}
+
+func (q *PriorityPointerQ) LaneStats(lane int) Stats {
+	return q.lanes[lane].Stats()
+}
+
+// The totals across every lane. The HighWaterMark is the sum of the
+// lanes' high water marks, so it may never have been reached. Reads
+// fail only when every lane is empty, FailedReads counts these rather
+// than the lanes' failed reads.
+func (q *PriorityPointerQ) Stats() Stats {
+	var total Stats
+	for _, lane := range q.lanes {
+		s := lane.Stats()
+		total.Capacity += s.Capacity
+		total.Occupancy += s.Occupancy
+		total.Written += s.Written
+		total.Read += s.Read
+		total.FailedWrites += s.FailedWrites
+		total.HighWaterMark += s.HighWaterMark
+	}
+	total.FailedReads = atomic.LoadInt64(&q.failedReads.Value)
+	return total
+}
@@ -0,0 +1,146 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package spscq
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fmstephe/flib/fmath"
+)
+
+// A point in time view of a queue. For PointerQ the counts are in
+// pointers, for ByteMsgQ and ByteChunkQ they are in bytes. ByteMsgQ
+// counts include message headers and any space skipped at the end of
+// the ring buffer.
+//
+// HighWaterMark is the largest occupancy seen when the writer or reader
+// refreshed its cached copy of the other's position, or when Stats was
+// called. It is sampled, keeping the cost off the read and write paths,
+// so a peak reached while both cached positions were still valid can be
+// missed.
+type Stats struct {
+	Capacity      int64
+	Occupancy     int64
+	Written       int64
+	Read          int64
+	FailedWrites  int64
+	FailedReads   int64
+	HighWaterMark int64
+}
+
+type StatsReporter interface {
+	Stats() Stats
+}
+
+// Safe to call from any goroutine, including goroutines which neither
+// read from nor write to the queue. The fields are loaded individually
+// so a Stats is not an atomic snapshot, but Occupancy is always
+// between 0 and Capacity.
+func (q *commonQ) Stats() Stats {
+	// read is loaded before write, so write >= read
+	read := atomic.LoadInt64(&q.read.Value)
+	write := atomic.LoadInt64(&q.write.Value)
+	occupancy := fmath.Min(write-read, q.size)
+	high := occupancy
+	if writeHigh := atomic.LoadInt64(&q.writeHigh.Value); writeHigh > high {
+		high = writeHigh
+	}
+	if readHigh := atomic.LoadInt64(&q.readHigh.Value); readHigh > high {
+		high = readHigh
+	}
+	return Stats{
+		Capacity:      q.size,
+		Occupancy:     occupancy,
+		Written:       write,
+		Read:          read,
+		FailedWrites:  atomic.LoadInt64(&q.failedWrites.Value),
+		FailedReads:   atomic.LoadInt64(&q.failedReads.Value),
+		HighWaterMark: high,
+	}
+}
+
+var registry = struct {
+	sync.Mutex
+	queues map[string]StatsReporter
+}{queues: make(map[string]StatsReporter)}
+
+// Registers a queue to be included in AllStats, and therefore in the
+// expvar and Prometheus exports.
+func Register(name string, q StatsReporter) error {
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.queues[name]; ok {
+		return errors.New(fmt.Sprintf("Queue (%s) is already registered", name))
+	}
+	registry.queues[name] = q
+	return nil
+}
+
+func Unregister(name string) {
+	registry.Lock()
+	defer registry.Unlock()
+	delete(registry.queues, name)
+}
+
+// Returns the current Stats for every registered queue, keyed by name.
+func AllStats() map[string]Stats {
+	registry.Lock()
+	defer registry.Unlock()
+	all := make(map[string]Stats, len(registry.queues))
+	for name, q := range registry.queues {
+		all[name] = q.Stats()
+	}
+	return all
+}
+
+// Publishes AllStats as an expvar variable. Like expvar.Publish this
+// panics if name is already in use.
+func PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return AllStats()
+	}))
+}
+
+// Writes AllStats to w in the Prometheus text exposition format. Each
+// queue is labelled with its registered name.
+func WritePrometheus(w io.Writer) error {
+	all := AllStats()
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := []struct {
+		name  string
+		kind  string
+		help  string
+		value func(Stats) int64
+	}{
+		{"spscq_capacity", "gauge", "Size of the queue's ring buffer.", func(s Stats) int64 { return s.Capacity }},
+		{"spscq_occupancy", "gauge", "Unread entries in the queue.", func(s Stats) int64 { return s.Occupancy }},
+		{"spscq_high_water_mark", "gauge", "Largest occupancy sampled, when a cached queue position was refreshed.", func(s Stats) int64 { return s.HighWaterMark }},
+		{"spscq_written_total", "counter", "Entries written to the queue.", func(s Stats) int64 { return s.Written }},
+		{"spscq_read_total", "counter", "Entries read from the queue.", func(s Stats) int64 { return s.Read }},
+		{"spscq_failed_writes_total", "counter", "Writes which failed because the queue was full.", func(s Stats) int64 { return s.FailedWrites }},
+		{"spscq_failed_reads_total", "counter", "Reads which failed because the queue was empty.", func(s Stats) int64 { return s.FailedReads }},
+	}
+	for _, m := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind); err != nil {
+			return err
+		}
+		for _, name := range names {
+			if _, err := fmt.Fprintf(w, "%s{queue=%q} %d\n", m.name, name, m.value(all[name])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
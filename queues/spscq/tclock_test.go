@@ -0,0 +1,147 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package spscq
+
+import (
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/fmstephe/flib/ftime"
+)
+
+func newFakeClock() *ftime.FakeClock {
+	return ftime.NewFakeClock(time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC), 1)
+}
+
+func checkPauses(t *testing.T, name string, c *ftime.FakeClock, pauses, paused int64) {
+	t.Helper()
+	if c.Pauses() != pauses || c.Paused() != paused {
+		t.Errorf("%s: expected %d pauses of %d ticks, found %d pauses of %d ticks", name, pauses, paused, c.Pauses(), c.Paused())
+	}
+}
+
+func TestQueueClock(t *testing.T) {
+	c := newFakeClock()
+	q, err := NewPointerQClock(4, 100, c)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	val := 1
+	// Failed reads and writes each pause once
+	q.ReadSingle()
+	q.AcquireRead(2)
+	checkPauses(t, "PointerQ", c, 2, 200)
+	for q.WriteSingle(unsafe.Pointer(&val)) {
+	}
+	checkPauses(t, "PointerQ", c, 3, 300)
+	// Successful reads don't pause
+	q.ReadSingle()
+	checkPauses(t, "PointerQ", c, 3, 300)
+
+	c = newFakeClock()
+	bq, err := NewByteMsgQClock(64, 50, c)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	bq.AcquireRead()
+	bq.AcquireWrite(128)
+	checkPauses(t, "ByteMsgQ", c, 2, 100)
+}
+
+func TestPriorityPointerQClock(t *testing.T) {
+	c := newFakeClock()
+	q, err := NewPriorityPointerQClock(4, 10, 2, c)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// Only the PriorityPointerQ pauses when every lane is empty
+	q.ReadSingle()
+	checkPauses(t, "PriorityPointerQ", c, 1, 10)
+	val := 1
+	for q.Lane(1).WriteSingle(unsafe.Pointer(&val)) {
+	}
+	checkPauses(t, "PriorityPointerQ", c, 2, 20)
+}
+
+func TestUnboundedPointerQClock(t *testing.T) {
+	c := newFakeClock()
+	q, err := NewUnboundedPointerQClock(2, 10, 1, c)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	val := 1
+	for q.WriteSingle(unsafe.Pointer(&val)) {
+	}
+	for q.ReadSingle() != nil {
+	}
+	checkPauses(t, "UnboundedPointerQ", c, 2, 20)
+}
+
+func TestWatchdogClock(t *testing.T) {
+	c := newFakeClock()
+	var stalls []Stall
+	w := NewWatchdogClock(time.Millisecond, 10*time.Millisecond, func(s Stall) {
+		stalls = append(stalls, s)
+	}, c)
+	q, _ := NewPointerQ(4, 0)
+	w.Watch("clock", q)
+	val := 1
+	for q.WriteSingle(unsafe.Pointer(&val)) {
+	}
+	c.AdvanceDuration(9 * time.Millisecond)
+	w.Check()
+	if len(stalls) != 0 {
+		t.Fatalf("Stall reported too early %v", stalls)
+	}
+	c.AdvanceDuration(time.Millisecond)
+	w.Check()
+	if len(stalls) != 1 || stalls[0].Stalled != 10*time.Millisecond {
+		t.Errorf("Expected a 10ms stall, found %v", stalls)
+	}
+}
+
+func TestCompositeClocks(t *testing.T) {
+	c := newFakeClock()
+	cq, err := NewByteChunkQClock(64, 10, 16, c)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	cq.AcquireRead()
+	checkPauses(t, "ByteChunkQ", c, 1, 10)
+
+	c = newFakeClock()
+	d, err := NewDuplexClock(64, 10, 2, c)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	d.Receive()
+	d.Poll(0)
+	checkPauses(t, "Duplex", c, 2, 20)
+
+	c = newFakeClock()
+	e, err := NewExchangerClock[int](2, 10, c)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	e.Receive()
+	checkPauses(t, "Exchanger", c, 1, 10)
+
+	c = newFakeClock()
+	wq, err := NewWeightedPriorityPointerQClock(4, 10, []int64{2, 1}, c)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	wq.ReadSingle()
+	checkPauses(t, "Weighted PriorityPointerQ", c, 1, 10)
+
+	c = newFakeClock()
+	aq, err := NewAgingPriorityPointerQClock(4, 10, 2, 3, c)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	aq.ReadSingle()
+	checkPauses(t, "Aging PriorityPointerQ", c, 1, 10)
+}
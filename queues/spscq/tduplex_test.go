@@ -0,0 +1,181 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package spscq
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestDuplexOutOfOrderPolls(t *testing.T) {
+	d, err := NewDuplex(1024, 0, 4)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var ids []int64
+	for i := 0; i < 4; i++ {
+		id, ok := d.Call([]byte(fmt.Sprintf("request %d", i)))
+		if !ok {
+			t.Fatalf("Call %d failed", i)
+		}
+		ids = append(ids, id)
+	}
+	if _, ok := d.Call([]byte("too many")); ok {
+		t.Errorf("Expected Call to fail with 4 requests outstanding")
+	}
+	if _, ok := d.Poll(ids[0]); ok {
+		t.Errorf("Expected no response before the server responds")
+	}
+	// Respond to the requests in reverse order
+	var received [][]byte
+	for id, req := d.Receive(); req != nil; id, req = d.Receive() {
+		received = append(received, append([]byte(fmt.Sprintf("%d:", id)), req...))
+		d.ReleaseRequest()
+	}
+	for i := len(received) - 1; i >= 0; i-- {
+		if !d.Respond(ids[i], received[i]) {
+			t.Fatalf("Respond %d failed", i)
+		}
+	}
+	for i, id := range ids {
+		resp, ok := d.Poll(id)
+		expected := fmt.Sprintf("%d:request %d", id, i)
+		if !ok || string(resp) != expected {
+			t.Errorf("Expected response %q, found %q", expected, resp)
+		}
+	}
+	if _, ok := d.Call([]byte("after")); !ok {
+		t.Errorf("Expected Call to succeed once responses have been polled")
+	}
+}
+
+// A Call must not reuse the slot of a request which is still outstanding
+func TestDuplexInterleavedPolls(t *testing.T) {
+	d, err := NewDuplex(1024, 0, 4)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	call := func(req string) (int64, bool) {
+		return d.Call([]byte(req))
+	}
+	serve := func() {
+		for id, req := d.Receive(); req != nil; id, req = d.Receive() {
+			if !d.Respond(id, req) {
+				t.Fatalf("Respond %d failed", id)
+			}
+			d.ReleaseRequest()
+		}
+	}
+	poll := func(id int64, expected string) {
+		resp, ok := d.Poll(id)
+		if !ok || string(resp) != expected {
+			t.Errorf("Expected response %q to %d, found %q %v", expected, id, resp, ok)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		if _, ok := call(fmt.Sprintf("request %d", i)); !ok {
+			t.Fatalf("Call %d failed", i)
+		}
+	}
+	serve()
+	poll(1, "request 1")
+	// The next id, 4, would share a slot with 0, which is still outstanding
+	if _, ok := call("request 4"); ok {
+		t.Errorf("Expected Call to fail while request 0 is outstanding")
+	}
+	poll(0, "request 0")
+	if id, ok := call("request 4"); !ok || id != 4 {
+		t.Fatalf("Expected Call to issue id 4, found %d %v", id, ok)
+	}
+	if id, ok := call("request 5"); !ok || id != 5 {
+		t.Fatalf("Expected Call to issue id 5, found %d %v", id, ok)
+	}
+	if _, ok := call("request 6"); ok {
+		t.Errorf("Expected Call to fail while request 2 is outstanding")
+	}
+	serve()
+	poll(5, "request 5")
+	poll(3, "request 3")
+	poll(4, "request 4")
+	poll(2, "request 2")
+}
+
+func TestDuplexWaitNotOutstanding(t *testing.T) {
+	d, err := NewDuplex(1024, 0, 4)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	mustPanic := func(name string, id int64) {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Expected Wait(%d) to panic, %s", id, name)
+			}
+		}()
+		d.Wait(id)
+	}
+	mustPanic("never issued", 0)
+	id, _ := d.Call([]byte("request"))
+	mustPanic("not yet issued", id+1)
+	mustPanic("negative", -1)
+	rid, req := d.Receive()
+	d.Respond(rid, req)
+	d.ReleaseRequest()
+	if resp := d.Wait(id); string(resp) != "request" {
+		t.Errorf("Expected response %q, found %q", "request", resp)
+	}
+	mustPanic("already polled", id)
+}
+
+func TestDuplexBadMaxOutstanding(t *testing.T) {
+	if _, err := NewDuplex(1024, 0, 3); err == nil {
+		t.Errorf("Expected error for maxOutstanding which is not a power of two")
+	}
+}
+
+func TestDuplexConcurrent(t *testing.T) {
+	const calls = 20 * 1000
+	d, _ := NewDuplex(1024, 0, 16)
+	go func() {
+		for served := 0; served < calls; {
+			id, req := d.Receive()
+			if req == nil {
+				backoff()
+				continue
+			}
+			resp := bytes.ToUpper(req)
+			d.ReleaseRequestLazy()
+			for !d.RespondLazy(id, resp) {
+				backoff()
+			}
+			served++
+		}
+	}()
+	outstanding := make([]int64, 0, 16)
+	for i := 0; i < calls; {
+		// Issue as many calls as we can, then wait for the oldest
+		id, ok := d.CallLazy([]byte(fmt.Sprintf("call %d", i)))
+		if ok {
+			outstanding = append(outstanding, id)
+			i++
+			if i < calls {
+				continue
+			}
+		}
+		for len(outstanding) > 0 {
+			resp, ok := d.PollLazy(outstanding[0])
+			for !ok {
+				backoff()
+				resp, ok = d.PollLazy(outstanding[0])
+			}
+			expected := fmt.Sprintf("CALL %d", i-len(outstanding))
+			if string(resp) != expected {
+				t.Fatalf("Expected response %q, found %q", expected, resp)
+			}
+			outstanding = outstanding[1:]
+		}
+		outstanding = outstanding[:0]
+	}
+}
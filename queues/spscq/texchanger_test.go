@@ -0,0 +1,104 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package spscq
+
+import (
+	"testing"
+)
+
+type exchangeMsg struct {
+	id      int64
+	payload [32]byte
+}
+
+func TestExchangerExhausted(t *testing.T) {
+	e, err := NewExchanger[exchangeMsg](4, 0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	seen := make(map[*exchangeMsg]bool)
+	for i := 0; i < 4; i++ {
+		msg := e.Acquire()
+		if msg == nil || seen[msg] {
+			t.Fatalf("Expected a new object, found %v", msg)
+		}
+		seen[msg] = true
+		e.Send(msg)
+	}
+	if msg := e.Acquire(); msg != nil {
+		t.Fatalf("Expected nil from exhausted Exchanger, found %v", msg)
+	}
+	msg := e.Receive()
+	e.Return(msg)
+	if recycled := e.Acquire(); recycled != msg {
+		t.Errorf("Expected returned object to be recycled")
+	}
+}
+
+func TestExchangerReturnForeign(t *testing.T) {
+	e, err := NewExchanger[exchangeMsg](4, 0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for i := 0; i < 4; i++ {
+		e.Send(e.Acquire())
+	}
+	for i := 0; i < 4; i++ {
+		e.Return(e.Receive())
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected Return to panic on an object not from this Exchanger")
+		}
+	}()
+	e.Return(new(exchangeMsg))
+}
+
+func TestExchangerNoAlloc(t *testing.T) {
+	e, _ := NewExchanger[exchangeMsg](16, 0)
+	allocs := testing.AllocsPerRun(1000, func() {
+		for i := 0; i < 16; i++ {
+			msg := e.Acquire()
+			msg.id = int64(i)
+			e.Send(msg)
+		}
+		for i := 0; i < 16; i++ {
+			e.Return(e.Receive())
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("Expected no allocations, found %f", allocs)
+	}
+}
+
+func TestExchangerConcurrent(t *testing.T) {
+	const msgs = 100 * 1000
+	e, _ := NewExchanger[exchangeMsg](64, 0)
+	done := make(chan bool)
+	go func() {
+		for i := int64(0); i < msgs; i++ {
+			msg := e.Acquire()
+			for msg == nil {
+				backoff()
+				msg = e.Acquire()
+			}
+			msg.id = i
+			e.SendLazy(msg)
+		}
+		done <- true
+	}()
+	for i := int64(0); i < msgs; i++ {
+		msg := e.ReceiveLazy()
+		for msg == nil {
+			backoff()
+			msg = e.ReceiveLazy()
+		}
+		if msg.id != i {
+			t.Fatalf("Expected message %d, found %d", i, msg.id)
+		}
+		e.ReturnLazy(msg)
+	}
+	<-done
+}
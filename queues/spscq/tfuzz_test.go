@@ -0,0 +1,185 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package spscq
+
+import (
+	"bytes"
+	"testing"
+)
+
+// These fuzz targets drive a queue from a single goroutine with
+// fuzzer-chosen sequences of operations. The reader and writer
+// acquire/release operations are interleaved in any order, and every
+// message read is compared against a simple model of the queue.
+//
+// Run with
+//	go test -fuzz=FuzzByteMsgQ
+//	go test -fuzz=FuzzByteChunkQ
+
+const (
+	fuzzAcquireWrite = iota
+	fuzzReleaseWrite
+	fuzzAcquireRead
+	fuzzReleaseRead
+	fuzzOps
+)
+
+// Tracks the messages released by the writer but not yet released by
+// the reader, and any message currently acquired by either side.
+type fuzzModel struct {
+	released [][]byte
+	writing  []byte
+	reading  []byte
+	count    byte
+}
+
+func (m *fuzzModel) fill(msg []byte) {
+	m.count++
+	for i := range msg {
+		msg[i] = m.count + byte(i)*7
+	}
+	m.writing = msg
+}
+
+func (m *fuzzModel) releaseWrite() {
+	m.released = append(m.released, append([]byte(nil), m.writing...))
+	m.writing = nil
+}
+
+func (m *fuzzModel) acquireRead(t *testing.T, msg []byte) {
+	if len(m.released) == 0 {
+		if msg != nil {
+			t.Fatalf("Read message %v from empty queue", msg)
+		}
+		return
+	}
+	if msg == nil {
+		t.Fatalf("Failed to read from queue with %d messages", len(m.released))
+	}
+	if !bytes.Equal(msg, m.released[0]) {
+		t.Fatalf("Expected to read %v, found %v", m.released[0], msg)
+	}
+	m.reading = msg
+}
+
+func (m *fuzzModel) releaseRead() {
+	m.released = m.released[1:]
+	m.reading = nil
+}
+
+func FuzzByteMsgQ(f *testing.F) {
+	f.Add(uint8(0), []byte{0, 1, 1, 0, 2, 0, 3, 0})
+	f.Add(uint8(2), []byte{0, 20, 1, 0, 0, 20, 1, 1, 2, 0, 3, 0, 0, 40, 2, 0, 1, 0, 3, 1, 2, 0})
+	f.Add(uint8(3), []byte{0, 100, 1, 0, 2, 0, 0, 100, 3, 0, 1, 0, 2, 0, 3, 0, 0, 3, 1, 0})
+	f.Add(uint8(1), []byte{0, 5, 1, 0, 0, 9, 1, 0, 2, 0, 3, 0, 0, 23, 2, 0, 1, 0, 3, 0, 2, 0, 3, 0})
+	f.Fuzz(func(t *testing.T, sizeExp uint8, ops []byte) {
+		size := int64(16) << (sizeExp % 8)
+		q, err := NewByteMsgQ(size, 0)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		m := &fuzzModel{}
+		for i := 0; i+1 < len(ops); i += 2 {
+			op, arg := ops[i]%fuzzOps, int64(ops[i+1])
+			switch {
+			case op == fuzzAcquireWrite && m.writing == nil:
+				if msg := q.AcquireWrite(arg % (size - headerSize + 1)); msg != nil {
+					m.fill(msg)
+				}
+			case op == fuzzReleaseWrite && m.writing != nil:
+				releaseWrite(q, arg)
+				m.releaseWrite()
+			case op == fuzzAcquireRead && m.reading == nil:
+				m.acquireRead(t, q.AcquireRead())
+			case op == fuzzReleaseRead && m.reading != nil:
+				releaseRead(q, arg)
+				m.releaseRead()
+			}
+		}
+		if m.writing != nil {
+			q.ReleaseWrite()
+			m.releaseWrite()
+		}
+		if m.reading != nil {
+			q.ReleaseRead()
+			m.releaseRead()
+		}
+		for len(m.released) > 0 {
+			m.acquireRead(t, q.AcquireRead())
+			q.ReleaseRead()
+			m.releaseRead()
+		}
+		// An empty queue must accept the largest possible message. The
+		// first attempt may fail after skipping the end of the ring
+		// buffer, a failed read will then release the skipped space.
+		if q.AcquireWrite(size-headerSize) == nil {
+			m.acquireRead(t, q.AcquireRead())
+			if q.AcquireWrite(size-headerSize) == nil {
+				t.Fatalf("Unable to write to empty queue %s", q.String())
+			}
+		}
+	})
+}
+
+func FuzzByteChunkQ(f *testing.F) {
+	f.Add(uint8(3), uint8(1), []byte{0, 0, 1, 0, 2, 0, 3, 0})
+	f.Add(uint8(4), uint8(2), []byte{0, 0, 1, 1, 0, 0, 2, 0, 1, 0, 0, 0, 3, 1, 2, 0, 1, 1, 3, 0})
+	f.Fuzz(func(t *testing.T, sizeExp, chunkShift uint8, ops []byte) {
+		size := int64(1) << (sizeExp % 12)
+		chunk := size >> (chunkShift % 4)
+		if chunk == 0 {
+			chunk = 1
+		}
+		q, err := NewByteChunkQ(size, 0, chunk)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		m := &fuzzModel{}
+		for i := 0; i+1 < len(ops); i += 2 {
+			op, arg := ops[i]%fuzzOps, int64(ops[i+1])
+			switch {
+			case op == fuzzAcquireWrite && m.writing == nil:
+				msg := q.AcquireWrite()
+				if msg == nil && int64(len(m.released)) < size/chunk {
+					t.Fatalf("Failed to write to queue with %d of %d chunks", len(m.released), size/chunk)
+				}
+				if msg != nil {
+					m.fill(msg)
+				}
+			case op == fuzzReleaseWrite && m.writing != nil:
+				releaseWrite(q, arg)
+				m.releaseWrite()
+			case op == fuzzAcquireRead && m.reading == nil:
+				m.acquireRead(t, q.AcquireRead())
+			case op == fuzzReleaseRead && m.reading != nil:
+				releaseRead(q, arg)
+				m.releaseRead()
+			}
+		}
+	})
+}
+
+type releaser interface {
+	ReleaseWrite()
+	ReleaseWriteLazy()
+	ReleaseRead()
+	ReleaseReadLazy()
+}
+
+func releaseWrite(q releaser, arg int64) {
+	if arg%2 == 0 {
+		q.ReleaseWrite()
+	} else {
+		q.ReleaseWriteLazy()
+	}
+}
+
+func releaseRead(q releaser, arg int64) {
+	if arg%2 == 0 {
+		q.ReleaseRead()
+	} else {
+		q.ReleaseReadLazy()
+	}
+}
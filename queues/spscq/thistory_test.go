@@ -0,0 +1,384 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package spscq
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"testing"
+	"unsafe"
+)
+
+// These tests run a producer and a consumer concurrently against a
+// queue using randomised schedules. Each side records the history of
+// messages it wrote or read. Afterwards we check that the consumer's
+// history is exactly the producer's history, i.e. messages are
+// delivered in FIFO order, none are lost and none are duplicated.
+//
+// Schedules randomly mix batch and single operations, strict and lazy
+// releases and calls to runtime.Gosched() between acquiring and
+// releasing. These forced preemption points let the other side observe
+// the queue in states which are rarely seen when both sides run
+// uninterrupted. Run with -race to also check the memory ordering of
+// the release paths.
+
+const historyMsgs = 20 * 1000
+
+var historySeeds = []int64{1, 2, 3, 4, 5, 6, 7, 8}
+
+type schedule struct {
+	r *rand.Rand
+}
+
+func newSchedule(seed int64) *schedule {
+	return &schedule{r: rand.New(rand.NewSource(seed))}
+}
+
+func (s *schedule) preempt() {
+	if s.r.Intn(8) == 0 {
+		runtime.Gosched()
+	}
+}
+
+func (s *schedule) lazy() bool {
+	return s.r.Intn(2) == 0
+}
+
+func (s *schedule) batch(max int64) int64 {
+	return s.r.Int63n(max) + 1
+}
+
+// Called when a read or write fails. Pause is 0 for these queues so we
+// must give the other side a chance to run.
+func backoff() {
+	runtime.Gosched()
+}
+
+func checkHistory(t *testing.T, name string, written, read []int64) {
+	if len(written) != len(read) {
+		t.Errorf("%s: wrote %d messages, read %d", name, len(written), len(read))
+	}
+	for i := 0; i < len(written) && i < len(read); i++ {
+		if written[i] != read[i] {
+			t.Errorf("%s: message %d written as %d, read as %d", name, i, written[i], read[i])
+			return
+		}
+	}
+}
+
+func TestPointerQHistory(t *testing.T) {
+	for _, seed := range historySeeds {
+		size := int64(1) << uint(seed)
+		q, err := NewPointerQ(size, 0)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		name := fmt.Sprintf("PointerQ(seed %d, size %d)", seed, size)
+		values := make([]int64, historyMsgs)
+		for i := range values {
+			values[i] = int64(i)
+		}
+		written := make(chan []int64)
+		go pointerQProducer(newSchedule(seed), q, q.size, values, written)
+		read := pointerQConsumer(newSchedule(-seed), q, q.size, int64(len(values)))
+		checkHistory(t, name, <-written, read)
+	}
+}
+
+// Batches are between 1 and maxBatch pointers
+func pointerQProducer(s *schedule, q PointerQueueV, maxBatch int64, values []int64, done chan []int64) {
+	history := make([]int64, 0, len(values))
+	for i := 0; i < len(values); {
+		if s.r.Intn(2) == 0 {
+			ptr := unsafe.Pointer(&values[i])
+			var ok bool
+			if s.lazy() {
+				ok = q.WriteSingleLazy(ptr)
+			} else {
+				ok = q.WriteSingle(ptr)
+			}
+			if !ok {
+				backoff()
+				continue
+			}
+			history = append(history, values[i])
+			i++
+			continue
+		}
+		batch := s.batch(maxBatch)
+		if remaining := int64(len(values) - i); batch > remaining {
+			batch = remaining
+		}
+		var buffer, wrapped []unsafe.Pointer
+		if s.r.Intn(2) == 0 {
+			buffer = q.AcquireWrite(batch)
+		} else {
+			buffer, wrapped = q.AcquireWriteV(batch)
+		}
+		if buffer == nil {
+			backoff()
+			continue
+		}
+		for _, b := range [][]unsafe.Pointer{buffer, wrapped} {
+			for j := range b {
+				b[j] = unsafe.Pointer(&values[i])
+				history = append(history, values[i])
+				i++
+			}
+		}
+		s.preempt()
+		if s.lazy() {
+			q.ReleaseWriteLazy()
+		} else {
+			q.ReleaseWrite()
+		}
+	}
+	done <- history
+}
+
+func pointerQConsumer(s *schedule, q PointerQueueV, maxBatch int64, msgs int64) []int64 {
+	history := make([]int64, 0, msgs)
+	for int64(len(history)) < msgs {
+		if s.r.Intn(2) == 0 {
+			var ptr unsafe.Pointer
+			if s.lazy() {
+				ptr = q.ReadSingleLazy()
+			} else {
+				ptr = q.ReadSingle()
+			}
+			if ptr == nil {
+				backoff()
+				continue
+			}
+			history = append(history, *(*int64)(ptr))
+			continue
+		}
+		var buffer, wrapped []unsafe.Pointer
+		if s.r.Intn(2) == 0 {
+			buffer = q.AcquireRead(s.batch(maxBatch))
+		} else {
+			buffer, wrapped = q.AcquireReadV(s.batch(maxBatch))
+		}
+		if buffer == nil {
+			backoff()
+			continue
+		}
+		for _, b := range [][]unsafe.Pointer{buffer, wrapped} {
+			for _, ptr := range b {
+				history = append(history, *(*int64)(ptr))
+			}
+		}
+		s.preempt()
+		if s.lazy() {
+			q.ReleaseReadLazy()
+		} else {
+			q.ReleaseRead()
+		}
+	}
+	return history
+}
+
+// Each message carries its id in the first 8 bytes, followed by a
+// pattern derived from the id. Returns -1 if the message is malformed.
+func fillMsg(msg []byte, id int64) {
+	binary.LittleEndian.PutUint64(msg, uint64(id))
+	for i := 8; i < len(msg); i++ {
+		msg[i] = byte(id) + byte(i)
+	}
+}
+
+func checkMsg(msg []byte) int64 {
+	if len(msg) < 8 {
+		return -1
+	}
+	id := int64(binary.LittleEndian.Uint64(msg))
+	for i := 8; i < len(msg); i++ {
+		if msg[i] != byte(id)+byte(i) {
+			return -1
+		}
+	}
+	return id
+}
+
+func TestByteMsgQHistory(t *testing.T) {
+	for _, seed := range historySeeds {
+		size := int64(32) << uint(seed)
+		q, err := NewByteMsgQ(size, 0)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		name := fmt.Sprintf("ByteMsgQ(seed %d, size %d)", seed, size)
+		written := make(chan []int64)
+		go byteMsgQProducer(newSchedule(seed), q, historyMsgs, written)
+		read := byteMsgQConsumer(newSchedule(-seed), q, historyMsgs)
+		checkHistory(t, name, <-written, read)
+	}
+}
+
+func byteMsgQProducer(s *schedule, q *ByteMsgQ, msgs int64, done chan []int64) {
+	history := make([]int64, 0, msgs)
+	for id := int64(0); id < msgs; {
+		// Messages range from the smallest which can carry an id up
+		// to the largest the queue can hold
+		msgSize := 8 + s.r.Int63n(q.size-headerSize-8+1)
+		if s.r.Intn(4) != 0 {
+			msgSize = 8 + s.r.Int63n(q.size/4)
+		}
+		msg := q.AcquireWrite(msgSize)
+		for msg == nil {
+			backoff()
+			msg = q.AcquireWrite(msgSize)
+		}
+		fillMsg(msg, id)
+		history = append(history, id)
+		id++
+		s.preempt()
+		if s.lazy() {
+			q.ReleaseWriteLazy()
+		} else {
+			q.ReleaseWrite()
+		}
+	}
+	done <- history
+}
+
+func byteMsgQConsumer(s *schedule, q *ByteMsgQ, msgs int64) []int64 {
+	history := make([]int64, 0, msgs)
+	for int64(len(history)) < msgs {
+		msg := q.AcquireRead()
+		if msg == nil {
+			backoff()
+			continue
+		}
+		history = append(history, checkMsg(msg))
+		s.preempt()
+		if s.lazy() {
+			q.ReleaseReadLazy()
+		} else {
+			q.ReleaseRead()
+		}
+	}
+	return history
+}
+
+func TestByteMsgQVHistory(t *testing.T) {
+	for _, seed := range historySeeds {
+		size := int64(32) << uint(seed)
+		q, err := NewByteMsgQ(size, 0)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		name := fmt.Sprintf("ByteMsgQ V(seed %d, size %d)", seed, size)
+		written := make(chan []int64)
+		go byteMsgQVProducer(newSchedule(seed), q, historyMsgs, written)
+		read := byteMsgQVConsumer(newSchedule(-seed), q, historyMsgs)
+		checkHistory(t, name, <-written, read)
+	}
+}
+
+func byteMsgQVProducer(s *schedule, q *ByteMsgQ, msgs int64, done chan []int64) {
+	history := make([]int64, 0, msgs)
+	msg := make([]byte, q.size)
+	for id := int64(0); id < msgs; {
+		msgSize := 8 + s.r.Int63n(q.size-headerSize-8+1)
+		buffer, wrapped := q.AcquireWriteV(msgSize)
+		for buffer == nil {
+			backoff()
+			buffer, wrapped = q.AcquireWriteV(msgSize)
+		}
+		fillMsg(msg[:msgSize], id)
+		n := copy(buffer, msg[:msgSize])
+		copy(wrapped, msg[n:msgSize])
+		history = append(history, id)
+		id++
+		s.preempt()
+		if s.lazy() {
+			q.ReleaseWriteLazy()
+		} else {
+			q.ReleaseWrite()
+		}
+	}
+	done <- history
+}
+
+func byteMsgQVConsumer(s *schedule, q *ByteMsgQ, msgs int64) []int64 {
+	history := make([]int64, 0, msgs)
+	msg := make([]byte, q.size)
+	for int64(len(history)) < msgs {
+		buffer, wrapped := q.AcquireReadV()
+		if buffer == nil {
+			backoff()
+			continue
+		}
+		n := copy(msg, buffer)
+		n += copy(msg[n:], wrapped)
+		history = append(history, checkMsg(msg[:n]))
+		s.preempt()
+		if s.lazy() {
+			q.ReleaseReadLazy()
+		} else {
+			q.ReleaseRead()
+		}
+	}
+	return history
+}
+
+func TestByteChunkQHistory(t *testing.T) {
+	for _, seed := range historySeeds {
+		chunk := int64(8) << uint(seed%3)
+		size := chunk << uint(seed%5)
+		q, err := NewByteChunkQ(size, 0, chunk)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		name := fmt.Sprintf("ByteChunkQ(seed %d, size %d, chunk %d)", seed, size, chunk)
+		written := make(chan []int64)
+		go byteChunkQProducer(newSchedule(seed), q, historyMsgs, written)
+		read := byteChunkQConsumer(newSchedule(-seed), q, historyMsgs)
+		checkHistory(t, name, <-written, read)
+	}
+}
+
+func byteChunkQProducer(s *schedule, q *ByteChunkQ, msgs int64, done chan []int64) {
+	history := make([]int64, 0, msgs)
+	for id := int64(0); id < msgs; {
+		chunk := q.AcquireWrite()
+		if chunk == nil {
+			backoff()
+			continue
+		}
+		fillMsg(chunk, id)
+		history = append(history, id)
+		id++
+		s.preempt()
+		if s.lazy() {
+			q.ReleaseWriteLazy()
+		} else {
+			q.ReleaseWrite()
+		}
+	}
+	done <- history
+}
+
+func byteChunkQConsumer(s *schedule, q *ByteChunkQ, msgs int64) []int64 {
+	history := make([]int64, 0, msgs)
+	for int64(len(history)) < msgs {
+		chunk := q.AcquireRead()
+		if chunk == nil {
+			backoff()
+			continue
+		}
+		history = append(history, checkMsg(chunk))
+		s.preempt()
+		if s.lazy() {
+			q.ReleaseReadLazy()
+		} else {
+			q.ReleaseRead()
+		}
+	}
+	return history
+}
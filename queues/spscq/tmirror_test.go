@@ -0,0 +1,102 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package spscq
+
+import (
+	"fmt"
+	"syscall"
+	"testing"
+)
+
+func newMirroredByteMsgQ(t *testing.T, size int64) *ByteMsgQ {
+	q, err := NewMirroredByteMsgQ(size, 0)
+	if err != nil {
+		t.Skipf("Mirrored queues not available: %s", err.Error())
+	}
+	return q
+}
+
+func TestMirroredByteSlice(t *testing.T) {
+	size := int64(syscall.Getpagesize())
+	buf, err := mirroredByteSlice(size)
+	if err != nil {
+		t.Skipf("Mirrored queues not available: %s", err.Error())
+	}
+	defer unmapMirrored(buf)
+	for i := int64(0); i < size; i++ {
+		buf[i] = byte(i)
+	}
+	for i := int64(0); i < size; i++ {
+		if buf[size+i] != byte(i) {
+			t.Fatalf("Mirror at %d does not match, expected %d found %d", i, byte(i), buf[size+i])
+		}
+	}
+	if _, err := mirroredByteSlice(size + 1); err == nil {
+		t.Errorf("Expected error for size which is not a multiple of the page size")
+	}
+}
+
+// Messages are written contiguously across the end of the ring buffer
+func TestMirroredByteMsgQNoSkip(t *testing.T) {
+	size := int64(syscall.Getpagesize())
+	q := newMirroredByteMsgQ(t, size)
+	defer q.Close()
+	msgSize := size/2 - headerSize + 3
+	for i := 0; i < 8; i++ {
+		msg := q.AcquireWrite(msgSize)
+		if int64(len(msg)) != msgSize {
+			t.Fatalf("Expected message of size %d, found %d", msgSize, len(msg))
+		}
+		fillMsg(msg, int64(i))
+		q.ReleaseWrite()
+		if id := checkMsg(q.AcquireRead()); id != int64(i) {
+			t.Fatalf("Expected message %d, found %d", i, id)
+		}
+		q.ReleaseRead()
+	}
+	// Every message was written directly after the previous one
+	if q.Stats().Written != 8*(msgSize+headerSize) {
+		t.Errorf("Expected %d bytes written, found %d", 8*(msgSize+headerSize), q.Stats().Written)
+	}
+}
+
+func TestMirroredByteMsgQHistory(t *testing.T) {
+	for _, seed := range historySeeds[:4] {
+		size := int64(syscall.Getpagesize()) << uint(seed%2)
+		q := newMirroredByteMsgQ(t, size)
+		name := fmt.Sprintf("Mirrored ByteMsgQ(seed %d, size %d)", seed, size)
+		written := make(chan []int64)
+		go byteMsgQProducer(newSchedule(seed), q, historyMsgs, written)
+		read := byteMsgQConsumer(newSchedule(-seed), q, historyMsgs)
+		checkHistory(t, name, <-written, read)
+		q.Close()
+	}
+}
+
+// Chunks need not divide the size of the ring buffer
+func TestMirroredByteChunkQ(t *testing.T) {
+	size := int64(syscall.Getpagesize())
+	chunk := int64(100)
+	q, err := NewMirroredByteChunkQ(size, 0, chunk)
+	if err != nil {
+		t.Skipf("Mirrored queues not available: %s", err.Error())
+	}
+	defer q.Close()
+	for i := int64(0); i < 3*size/chunk; i++ {
+		buffer := q.AcquireWrite()
+		if int64(len(buffer)) != chunk {
+			t.Fatalf("Expected chunk of size %d, found %d", chunk, len(buffer))
+		}
+		fillMsg(buffer, i)
+		q.ReleaseWrite()
+		if id := checkMsg(q.AcquireRead()); id != i {
+			t.Fatalf("Expected chunk %d, found %d", i, id)
+		}
+		q.ReleaseRead()
+	}
+	if _, err := NewMirroredByteChunkQ(size, 0, size+1); err == nil {
+		t.Errorf("Expected error for chunk larger than size")
+	}
+}
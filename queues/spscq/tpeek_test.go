@@ -0,0 +1,203 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package spscq
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestPointerQPeek(t *testing.T) {
+	q, _ := NewPointerQ(8, 0)
+	if q.Peek() != nil || q.PeekN(4) != nil {
+		t.Errorf("Expected nil peeking into empty queue")
+	}
+	if !q.IsEmpty() || q.Len() != 0 || q.Cap() != 8 {
+		t.Errorf("Expected empty queue of capacity 8, found Len() %d Cap() %d", q.Len(), q.Cap())
+	}
+	values := []int{0, 1, 2, 3, 4}
+	for i := range values {
+		q.WriteSingle(unsafe.Pointer(&values[i]))
+	}
+	if q.IsEmpty() || q.Len() != 5 {
+		t.Errorf("Expected Len() 5, found %d", q.Len())
+	}
+	for i := 0; i < 2; i++ {
+		if ptr := q.Peek(); ptr != unsafe.Pointer(&values[0]) {
+			t.Errorf("Expected Peek() to return first value")
+		}
+	}
+	peeked := q.PeekN(10)
+	if len(peeked) != 5 {
+		t.Errorf("Expected to peek 5 pointers, found %d", len(peeked))
+	}
+	if q.FailedReads() != 0 || q.Len() != 5 {
+		t.Errorf("Peek must not consume or fail reads")
+	}
+	var drained []int
+	count := q.Drain(func(ptr unsafe.Pointer) {
+		drained = append(drained, *(*int)(ptr))
+	})
+	if count != 5 || len(drained) != 5 {
+		t.Fatalf("Expected to drain 5 pointers, found %d", count)
+	}
+	for i := range drained {
+		if drained[i] != i {
+			t.Errorf("Expected %d drained at %d, found %d", i, i, drained[i])
+		}
+	}
+	if !q.IsEmpty() || q.Drain(func(unsafe.Pointer) {}) != 0 {
+		t.Errorf("Expected queue to be empty after Drain()")
+	}
+}
+
+// Drain across the end of the ring buffer
+func TestPointerQDrainWrapped(t *testing.T) {
+	q, _ := NewPointerQ(8, 0)
+	val := 1
+	for i := 0; i < 6; i++ {
+		q.WriteSingle(unsafe.Pointer(&val))
+		q.ReadSingle()
+	}
+	for i := 0; i < 8; i++ {
+		q.WriteSingle(unsafe.Pointer(&val))
+	}
+	if count := q.Drain(func(unsafe.Pointer) {}); count != 8 {
+		t.Errorf("Expected to drain 8 pointers, found %d", count)
+	}
+	if !q.IsEmpty() {
+		t.Errorf("Expected queue to be empty after Drain()")
+	}
+}
+
+func TestByteMsgQPeekDrain(t *testing.T) {
+	q, _ := NewByteMsgQ(64, 0)
+	if q.Peek() != nil {
+		t.Errorf("Expected nil peeking into empty queue")
+	}
+	// Write enough messages to skip the end of the ring buffer
+	count := int64(0)
+	for id := int64(0); id < 5; id++ {
+		fillMsg(q.AcquireWrite(12), id)
+		q.ReleaseWrite()
+		if checkMsg(q.Peek()) != count {
+			t.Fatalf("Expected to peek message %d", count)
+		}
+		count += q.Drain(func(msg []byte) {
+			if checkMsg(msg) != count {
+				t.Fatalf("Expected to drain message %d, found %d", count, checkMsg(msg))
+			}
+		})
+	}
+	for id := int64(0); id < 3; id++ {
+		fillMsg(q.AcquireWrite(12), id)
+		q.ReleaseWrite()
+	}
+	var ids []int64
+	q.Drain(func(msg []byte) {
+		ids = append(ids, checkMsg(msg))
+	})
+	if len(ids) != 3 || ids[0] != 0 || ids[1] != 1 || ids[2] != 2 {
+		t.Errorf("Expected to drain messages 0, 1 and 2, found %v", ids)
+	}
+	if !q.IsEmpty() || q.FailedReads() != 0 {
+		t.Errorf("Expected empty queue with no failed reads")
+	}
+}
+
+func TestByteMsgQPeekN(t *testing.T) {
+	q, _ := NewByteMsgQ(64, 0)
+	peek := func(n int64) []int64 {
+		var ids []int64
+		count := q.PeekN(n, func(msg []byte) {
+			ids = append(ids, checkMsg(msg))
+		})
+		if count != int64(len(ids)) {
+			t.Errorf("Expected PeekN to return %d, found %d", len(ids), count)
+		}
+		return ids
+	}
+	if ids := peek(4); len(ids) != 0 {
+		t.Errorf("Expected to peek nothing in empty queue, found %v", ids)
+	}
+	for id := int64(0); id < 3; id++ {
+		fillMsg(q.AcquireWrite(12), id)
+		q.ReleaseWrite()
+	}
+	if ids := peek(2); len(ids) != 2 || ids[0] != 0 || ids[1] != 1 {
+		t.Errorf("Expected to peek messages 0 and 1, found %v", ids)
+	}
+	if ids := peek(10); len(ids) != 3 || ids[2] != 2 {
+		t.Errorf("Expected to peek messages 0, 1 and 2, found %v", ids)
+	}
+	if q.Len() != 60 || q.FailedReads() != 0 {
+		t.Errorf("PeekN must not consume or fail reads")
+	}
+	q.Drain(func([]byte) {})
+	// The next messages skip the end of the ring buffer
+	for id := int64(3); id < 5; id++ {
+		fillMsg(q.AcquireWrite(12), id)
+		q.ReleaseWrite()
+	}
+	if ids := peek(10); len(ids) != 2 || ids[0] != 3 || ids[1] != 4 {
+		t.Errorf("Expected to peek messages 3 and 4, found %v", ids)
+	}
+	if count := q.Drain(func([]byte) {}); count != 2 {
+		t.Errorf("Expected to drain 2 messages after PeekN, found %d", count)
+	}
+}
+
+func TestByteChunkQPeekN(t *testing.T) {
+	q, _ := NewByteChunkQ(64, 0, 16)
+	if q.PeekN(2) != nil {
+		t.Errorf("Expected nil peeking into empty queue")
+	}
+	for id := int64(0); id < 3; id++ {
+		fillMsg(q.AcquireWrite(), id)
+		q.ReleaseWrite()
+	}
+	if peeked := q.PeekN(2); len(peeked) != 32 || checkMsg(peeked[16:]) != 1 {
+		t.Errorf("Expected to peek chunks 0 and 1, found %d bytes", len(peeked))
+	}
+	if peeked := q.PeekN(10); len(peeked) != 48 || checkMsg(peeked[32:]) != 2 {
+		t.Errorf("Expected to peek chunks 0, 1 and 2, found %d bytes", len(peeked))
+	}
+	if q.Len() != 48 || q.FailedReads() != 0 {
+		t.Errorf("PeekN must not consume or fail reads")
+	}
+	q.Drain(func([]byte) {})
+	for id := int64(3); id < 6; id++ {
+		fillMsg(q.AcquireWrite(), id)
+		q.ReleaseWrite()
+	}
+	// Truncated at the end of the ring buffer
+	if peeked := q.PeekN(3); len(peeked) != 16 || checkMsg(peeked) != 3 {
+		t.Errorf("Expected to peek chunk 3 alone, found %d bytes", len(peeked))
+	}
+}
+
+func TestByteChunkQPeekDrain(t *testing.T) {
+	q, _ := NewByteChunkQ(64, 0, 16)
+	if q.Peek() != nil {
+		t.Errorf("Expected nil peeking into empty queue")
+	}
+	for id := int64(0); id < 3; id++ {
+		fillMsg(q.AcquireWrite(), id)
+		q.ReleaseWrite()
+	}
+	if checkMsg(q.Peek()) != 0 || q.Len() != 48 {
+		t.Errorf("Expected to peek first chunk without consuming it")
+	}
+	var ids []int64
+	count := q.Drain(func(chunk []byte) {
+		ids = append(ids, checkMsg(chunk))
+	})
+	if count != 3 || ids[0] != 0 || ids[1] != 1 || ids[2] != 2 {
+		t.Errorf("Expected to drain chunks 0, 1 and 2, found %v", ids)
+	}
+	if !q.IsEmpty() {
+		t.Errorf("Expected queue to be empty after Drain()")
+	}
+}
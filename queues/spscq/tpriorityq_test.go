@@ -0,0 +1,200 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package spscq
+
+import (
+	"testing"
+	"unsafe"
+)
+
+// Each value written records its lane, so we can see the order in
+// which lanes were read.
+type laneValue struct {
+	lane int
+	seq  int
+}
+
+func fillLanes(t *testing.T, q *PriorityPointerQ, perLane int) {
+	for lane := 0; lane < q.Lanes(); lane++ {
+		for seq := 0; seq < perLane; seq++ {
+			if !q.Lane(lane).WriteSingle(unsafe.Pointer(&laneValue{lane: lane, seq: seq})) {
+				t.Fatalf("Unable to write to lane %d", lane)
+			}
+		}
+	}
+}
+
+// Reads every value singly, returning the lanes read in order
+func readLanes(t *testing.T, q *PriorityPointerQ) []int {
+	var lanes []int
+	next := make([]int, q.Lanes())
+	for ptr := q.ReadSingle(); ptr != nil; ptr = q.ReadSingle() {
+		v := (*laneValue)(ptr)
+		if v.lane != q.LastLane() {
+			t.Fatalf("Read value from lane %d, LastLane is %d", v.lane, q.LastLane())
+		}
+		if v.seq != next[v.lane] {
+			t.Fatalf("Expected seq %d from lane %d, found %d", next[v.lane], v.lane, v.seq)
+		}
+		next[v.lane]++
+		lanes = append(lanes, v.lane)
+	}
+	return lanes
+}
+
+func checkLanes(t *testing.T, expected, found []int) {
+	if len(expected) != len(found) {
+		t.Fatalf("Expected lanes %v, found %v", expected, found)
+	}
+	for i := range expected {
+		if expected[i] != found[i] {
+			t.Fatalf("Expected lanes %v, found %v", expected, found)
+		}
+	}
+}
+
+func TestPriorityPointerQStrict(t *testing.T) {
+	q, err := NewPriorityPointerQ(8, 0, 3)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	fillLanes(t, q, 2)
+	checkLanes(t, []int{0, 0, 1, 1, 2, 2}, readLanes(t, q))
+	// A higher priority write overtakes everything already queued
+	fillLanes(t, q, 1)
+	q.Lane(2).WriteSingle(unsafe.Pointer(&laneValue{lane: 2, seq: 1}))
+	ptr := q.ReadSingle()
+	if v := (*laneValue)(ptr); v.lane != 0 {
+		t.Errorf("Expected to read lane 0, read lane %d", v.lane)
+	}
+	q.Lane(0).WriteSingle(unsafe.Pointer(&laneValue{lane: 0, seq: 1}))
+	buffer := q.AcquireRead(8)
+	if len(buffer) != 1 || q.LastLane() != 0 {
+		t.Errorf("Expected batch of 1 from lane 0, found %d from lane %d", len(buffer), q.LastLane())
+	}
+	q.ReleaseRead()
+	buffer = q.AcquireRead(8)
+	if len(buffer) != 1 || q.LastLane() != 1 {
+		t.Errorf("Expected batch of 1 from lane 1, found %d from lane %d", len(buffer), q.LastLane())
+	}
+	q.ReleaseReadLazy()
+	buffer, wrapped := q.AcquireReadV(8)
+	if len(buffer)+len(wrapped) != 2 || q.LastLane() != 2 {
+		t.Errorf("Expected batch of 2 from lane 2, found %d from lane %d", len(buffer)+len(wrapped), q.LastLane())
+	}
+	q.ReleaseRead()
+	if q.AcquireRead(8) != nil {
+		t.Errorf("Expected empty queue")
+	}
+}
+
+func TestPriorityPointerQWeighted(t *testing.T) {
+	q, err := NewWeightedPriorityPointerQ(16, 0, []int64{3, 2, 1})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	fillLanes(t, q, 6)
+	expected := []int{
+		0, 0, 0, 1, 1, 2,
+		0, 0, 0, 1, 1, 2,
+		// Lane 0 is empty, lanes 1 and 2 share what's left
+		1, 1, 2,
+		2, 2, 2,
+	}
+	checkLanes(t, expected, readLanes(t, q))
+}
+
+func TestPriorityPointerQAging(t *testing.T) {
+	q, err := NewAgingPriorityPointerQ(16, 0, 3, 2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	fillLanes(t, q, 4)
+	expected := []int{
+		// Lanes 1 and 2 age while lane 0 is read, lane 1 is read
+		// first as it has higher priority
+		0, 0, 1, 2,
+		// Lane 0 has now aged too, once every lane has aged they
+		// take turns
+		0, 1, 2,
+		0, 1, 2,
+		1, 2,
+	}
+	checkLanes(t, expected, readLanes(t, q))
+}
+
+func TestPriorityPointerQStats(t *testing.T) {
+	q, err := NewPriorityPointerQ(4, 0, 2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	fillLanes(t, q, 3)
+	q.ReadSingle()
+	if s := q.LaneStats(0); s.Written != 3 || s.Read != 1 || s.Occupancy != 2 {
+		t.Errorf("Unexpected lane 0 stats %v", s)
+	}
+	if s := q.LaneStats(1); s.Written != 3 || s.Read != 0 || s.Occupancy != 3 {
+		t.Errorf("Unexpected lane 1 stats %v", s)
+	}
+	for q.ReadSingle() != nil {
+	}
+	expected := Stats{Capacity: 8, Written: 6, Read: 6, FailedReads: 1, HighWaterMark: 6}
+	if s := q.Stats(); s != expected {
+		t.Errorf("Expected %v, found %v", expected, s)
+	}
+}
+
+func TestPriorityPointerQErrors(t *testing.T) {
+	if _, err := NewPriorityPointerQ(8, 0, 0); err == nil {
+		t.Errorf("Expected error for 0 lanes")
+	}
+	if _, err := NewPriorityPointerQ(7, 0, 2); err == nil {
+		t.Errorf("Expected error for size which is not a power of two")
+	}
+	if _, err := NewWeightedPriorityPointerQ(8, 0, []int64{1, 0}); err == nil {
+		t.Errorf("Expected error for weight of 0")
+	}
+	if _, err := NewAgingPriorityPointerQ(8, 0, 2, 0); err == nil {
+		t.Errorf("Expected error for maxAge of 0")
+	}
+}
+
+// Each lane has its own writer, the reader must see each lane's values
+// in order.
+func TestPriorityPointerQConcurrent(t *testing.T) {
+	const lanes, perLane = 3, 10 * 1000
+	q, err := NewAgingPriorityPointerQ(64, 0, lanes, 4)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for lane := 0; lane < lanes; lane++ {
+		go func(lane int) {
+			values := make([]laneValue, perLane)
+			for seq := range values {
+				values[seq] = laneValue{lane: lane, seq: seq}
+				for !q.Lane(lane).WriteSingle(unsafe.Pointer(&values[seq])) {
+					backoff()
+				}
+			}
+		}(lane)
+	}
+	next := make([]int, lanes)
+	for read := 0; read < lanes*perLane; {
+		buffer := q.AcquireRead(16)
+		if buffer == nil {
+			backoff()
+			continue
+		}
+		for _, ptr := range buffer {
+			v := (*laneValue)(ptr)
+			if v.lane != q.LastLane() || v.seq != next[v.lane] {
+				t.Fatalf("Expected seq %d from lane %d, found %v", next[q.LastLane()], q.LastLane(), *v)
+			}
+			next[v.lane]++
+		}
+		read += len(buffer)
+		q.ReleaseRead()
+	}
+}
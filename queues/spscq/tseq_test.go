@@ -0,0 +1,154 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package spscq
+
+import (
+	"encoding/binary"
+	"testing"
+	"unsafe"
+)
+
+func TestPointerQSeq(t *testing.T) {
+	q, err := NewPointerQ(8, 0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	value := int64(1)
+	ptr := unsafe.Pointer(&value)
+	for round := int64(0); round < 10; round++ {
+		buffer, seq := q.AcquireWriteSeq(5)
+		if seq != q.WriteSeq() || seq != round*5 {
+			t.Fatalf("Expected write seq %d, found %d", round*5, seq)
+		}
+		for i := range buffer {
+			buffer[i] = ptr
+		}
+		q.ReleaseWrite()
+		// The batch may be truncated at the end of the ring buffer
+		for read := int64(0); read < int64(len(buffer)); {
+			batch, seq := q.AcquireReadSeq(5)
+			if seq != round*5+read {
+				t.Fatalf("Expected read seq %d, found %d", round*5+read, seq)
+			}
+			read += int64(len(batch))
+			q.ReleaseReadLazy()
+		}
+		if q.ReadSeq() != q.WriteSeq() {
+			t.Fatalf("Expected read seq %d to equal write seq %d", q.ReadSeq(), q.WriteSeq())
+		}
+		// Write the rest of the truncated batch
+		if rest := 5 - int64(len(buffer)); rest > 0 {
+			buffer = q.AcquireWrite(rest)
+			for i := range buffer {
+				buffer[i] = ptr
+			}
+			q.ReleaseWrite()
+			q.AcquireRead(rest)
+			q.ReleaseRead()
+		}
+	}
+	q.WriteSingle(ptr)
+	if q.WriteSeq() != 51 || q.WritePosition() != 51 {
+		t.Errorf("Expected write seq 51, found %d", q.WriteSeq())
+	}
+	q.ReadSingleLazy()
+	if q.ReadSeq() != 51 || q.ReadPosition() != 51 {
+		t.Errorf("Expected read seq 51, found %d", q.ReadSeq())
+	}
+}
+
+func TestByteMsgQSeq(t *testing.T) {
+	q, err := NewByteMsgQ(128, 0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// Messages of varying sizes, so space is skipped at the end of the
+	// ring buffer, the sequence numbers count messages not bytes
+	for i := int64(0); i < 100; i++ {
+		msg, seq := q.AcquireWriteSeq(8 + i%40)
+		if msg == nil {
+			t.Fatalf("Unable to write message %d", i)
+		}
+		if seq != i {
+			t.Fatalf("Expected write seq %d, found %d", i, seq)
+		}
+		binary.LittleEndian.PutUint64(msg, uint64(seq))
+		if i%2 == 0 {
+			q.ReleaseWrite()
+		} else {
+			q.ReleaseWriteLazy()
+		}
+		msg, seq = q.AcquireReadSeq()
+		if seq != i || int64(binary.LittleEndian.Uint64(msg)) != i {
+			t.Fatalf("Expected read seq %d, found %d with message %d", i, seq, binary.LittleEndian.Uint64(msg))
+		}
+		if i%2 == 0 {
+			q.ReleaseRead()
+		} else {
+			q.ReleaseReadLazy()
+		}
+	}
+	if q.WriteSeq() != 100 || q.ReadSeq() != 100 {
+		t.Errorf("Expected write and read seq 100, found %d %d", q.WriteSeq(), q.ReadSeq())
+	}
+	if q.WritePosition() <= 100 {
+		t.Errorf("Expected write position in bytes, found %d", q.WritePosition())
+	}
+	// Failed reads and writes, and releases without an acquire, don't
+	// count as messages
+	q.AcquireRead()
+	q.ReleaseRead()
+	q.AcquireWrite(128)
+	q.ReleaseWrite()
+	if q.WriteSeq() != 100 || q.ReadSeq() != 100 {
+		t.Errorf("Expected write and read seq 100, found %d %d", q.WriteSeq(), q.ReadSeq())
+	}
+	for i := 0; i < 3; i++ {
+		q.AcquireWrite(8)
+		q.ReleaseWrite()
+	}
+	if count := q.Drain(func([]byte) {}); count != 3 {
+		t.Fatalf("Expected to drain 3 messages, drained %d", count)
+	}
+	if q.ReadSeq() != 103 {
+		t.Errorf("Expected read seq 103 after drain, found %d", q.ReadSeq())
+	}
+}
+
+// The writer embeds each message's write seq, the reader checks it
+// against the read seq.
+func TestByteMsgQSeqConcurrent(t *testing.T) {
+	const msgs = 20 * 1000
+	q, err := NewByteMsgQ(256, 0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	go func() {
+		for i := int64(0); i < msgs; i++ {
+			msg, seq := q.AcquireWriteSeq(8 + i%64)
+			for msg == nil {
+				backoff()
+				msg, seq = q.AcquireWriteSeq(8 + i%64)
+			}
+			binary.LittleEndian.PutUint64(msg, uint64(seq))
+			q.ReleaseWriteLazy()
+		}
+	}()
+	for i := int64(0); i < msgs; {
+		msg, seq := q.AcquireReadSeq()
+		if msg == nil {
+			backoff()
+			continue
+		}
+		if written := int64(binary.LittleEndian.Uint64(msg)); written != seq || seq != i {
+			t.Fatalf("Expected seq %d, read %d, written as %d", i, seq, written)
+		}
+		if q.WriteSeq() <= seq {
+			t.Fatalf("Read message %d before write seq %d passed it", seq, q.WriteSeq())
+		}
+		q.ReleaseRead()
+		i++
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package spscq
+
+import (
+	"testing"
+	"unsafe"
+)
+
+var _ PointerQueueV = (*PointerQ)(nil)
+var _ ByteMsgQueueV = (*ByteMsgQ)(nil)
+
+// AcquireWrite returns as many slots as are free, rather than failing
+// when fewer than bufferSize are free
+func TestPointerQAcquireWritePartial(t *testing.T) {
+	q, _ := NewPointerQ(8, 0)
+	val := 1
+	for i := 0; i < 5; i++ {
+		q.WriteSingle(unsafe.Pointer(&val))
+	}
+	if buffer := q.AcquireWrite(8); len(buffer) != 3 {
+		t.Fatalf("Expected to acquire the 3 free slots, found %d", len(buffer))
+	}
+	q.ReleaseWrite()
+	if buffer := q.AcquireWrite(1); buffer != nil {
+		t.Errorf("Expected full queue to fail write")
+	}
+	if q.FailedWrites() != 1 {
+		t.Errorf("Expected 1 failed write, found %d", q.FailedWrites())
+	}
+}
+
+func TestPointerQAcquireWriteV(t *testing.T) {
+	q, _ := NewPointerQ(8, 0)
+	val := 1
+	ptr := unsafe.Pointer(&val)
+	// Move the read and write positions near the end of the ring buffer
+	for i := 0; i < 6; i++ {
+		q.WriteSingle(ptr)
+		q.ReadSingle()
+	}
+	buffer, wrapped := q.AcquireWriteV(8)
+	if len(buffer) != 2 || len(wrapped) != 6 {
+		t.Fatalf("Expected split of 2 and 6, found %d and %d", len(buffer), len(wrapped))
+	}
+	for i := range buffer {
+		buffer[i] = ptr
+	}
+	for i := range wrapped {
+		wrapped[i] = ptr
+	}
+	q.ReleaseWrite()
+	if buffer, wrapped := q.AcquireWriteV(1); buffer != nil || wrapped != nil {
+		t.Errorf("Expected full queue to fail write")
+	}
+	buffer, wrapped = q.AcquireReadV(10)
+	if len(buffer) != 2 || len(wrapped) != 6 {
+		t.Fatalf("Expected split of 2 and 6, found %d and %d", len(buffer), len(wrapped))
+	}
+	q.ReleaseRead()
+	for i, p := range q.ringBuffer {
+		if p != nil {
+			t.Errorf("Pointer at %d not cleared after ReleaseRead()", i)
+		}
+	}
+}
+
+func TestByteMsgQAcquireWriteV(t *testing.T) {
+	q, _ := NewByteMsgQ(64, 0)
+	q.AcquireWrite(32)
+	q.ReleaseWrite()
+	q.AcquireRead()
+	q.ReleaseRead()
+	// 40 bytes from 64, and the header starts at 40, wrapping around
+	buffer, wrapped := q.AcquireWriteV(48)
+	if len(buffer) != 16 || len(wrapped) != 32 {
+		t.Fatalf("Expected split of 16 and 32, found %d and %d", len(buffer), len(wrapped))
+	}
+	for i := range buffer {
+		buffer[i] = byte(i)
+	}
+	for i := range wrapped {
+		wrapped[i] = byte(len(buffer) + i)
+	}
+	q.ReleaseWrite()
+	buffer, wrapped = q.AcquireReadV()
+	if len(buffer) != 16 || len(wrapped) != 32 {
+		t.Fatalf("Expected split of 16 and 32, found %d and %d", len(buffer), len(wrapped))
+	}
+	for i, b := range append(buffer, wrapped...) {
+		if b != byte(i) {
+			t.Fatalf("Expected %d at %d, found %d", i, i, b)
+		}
+	}
+	q.ReleaseRead()
+}
+
+func TestByteMsgQAcquireReadWrapped(t *testing.T) {
+	q, _ := NewByteMsgQ(64, 0)
+	q.AcquireWrite(32)
+	q.ReleaseWrite()
+	q.AcquireRead()
+	q.ReleaseRead()
+	buffer, wrapped := q.AcquireWriteV(48)
+	for i := range buffer {
+		buffer[i] = byte(i)
+	}
+	for i := range wrapped {
+		wrapped[i] = byte(len(buffer) + i)
+	}
+	q.ReleaseWrite()
+	check := func(name string, msg []byte) {
+		if len(msg) != 48 {
+			t.Fatalf("%s: expected a 48 byte copy, found %d bytes", name, len(msg))
+		}
+		for i, b := range msg {
+			if b != byte(i) {
+				t.Fatalf("%s: expected %d at %d, found %d", name, i, i, b)
+			}
+		}
+	}
+	check("Peek", q.Peek())
+	q.PeekN(1, func(msg []byte) {
+		check("PeekN", msg)
+	})
+	check("AcquireRead", q.AcquireRead())
+	q.ReleaseRead()
+	if !q.IsEmpty() {
+		t.Errorf("Expected queue to be empty after ReleaseRead()")
+	}
+}
+
+// Drain copies wrapped messages, as AcquireRead does
+func TestByteMsgQDrainWrapped(t *testing.T) {
+	q, _ := NewByteMsgQ(64, 0)
+	q.AcquireWrite(32)
+	q.ReleaseWrite()
+	q.AcquireRead()
+	q.ReleaseRead()
+	buffer, wrapped := q.AcquireWriteV(48)
+	fillMsg(buffer, 7)
+	for i := range wrapped {
+		wrapped[i] = byte(7) + byte(len(buffer)+i)
+	}
+	q.ReleaseWrite()
+	count := q.Drain(func(msg []byte) {
+		if len(msg) != 48 || checkMsg(msg) != 7 {
+			t.Errorf("Expected message 7 of 48 bytes, found %v", msg)
+		}
+	})
+	if count != 1 {
+		t.Errorf("Expected to drain 1 message, found %d", count)
+	}
+}
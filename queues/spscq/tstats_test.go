@@ -0,0 +1,104 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package spscq
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestStats(t *testing.T) {
+	q, err := NewPointerQ(8, 0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	val := 1
+	ptr := unsafe.Pointer(&val)
+	for i := 0; i < 6; i++ {
+		q.WriteSingle(ptr)
+	}
+	for i := 0; i < 2; i++ {
+		q.ReadSingle()
+	}
+	// Fill the queue and then fail a write
+	for q.WriteSingle(ptr) {
+	}
+	// Empty the queue and then fail a read
+	for q.ReadSingle() != nil {
+	}
+	stats := q.Stats()
+	expected := Stats{
+		Capacity:      8,
+		Occupancy:     0,
+		Written:       10,
+		Read:          10,
+		FailedWrites:  1,
+		FailedReads:   1,
+		HighWaterMark: 8,
+	}
+	if stats != expected {
+		t.Errorf("Expected %+v, found %+v", expected, stats)
+	}
+}
+
+func TestStatsOccupancy(t *testing.T) {
+	q, err := NewByteChunkQ(64, 0, 8)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for i := int64(1); i <= 8; i++ {
+		q.AcquireWrite()
+		q.ReleaseWrite()
+		if occupancy := q.Stats().Occupancy; occupancy != i*8 {
+			t.Errorf("Expected occupancy %d, found %d", i*8, occupancy)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	q, _ := NewPointerQ(8, 0)
+	if err := Register("test-register", q); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer Unregister("test-register")
+	if err := Register("test-register", q); err == nil {
+		t.Errorf("Expected error registering duplicate queue name")
+	}
+	if _, ok := AllStats()["test-register"]; !ok {
+		t.Errorf("Registered queue missing from AllStats()")
+	}
+	Unregister("test-register")
+	if _, ok := AllStats()["test-register"]; ok {
+		t.Errorf("Unregistered queue found in AllStats()")
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	q, _ := NewPointerQ(16, 0)
+	val := 1
+	q.WriteSingle(unsafe.Pointer(&val))
+	if err := Register("test-prometheus", q); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer Unregister("test-prometheus")
+	var buf bytes.Buffer
+	if err := WritePrometheus(&buf); err != nil {
+		t.Fatal(err.Error())
+	}
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE spscq_capacity gauge",
+		`spscq_capacity{queue="test-prometheus"} 16`,
+		`spscq_occupancy{queue="test-prometheus"} 1`,
+		`spscq_written_total{queue="test-prometheus"} 1`,
+		`spscq_read_total{queue="test-prometheus"} 0`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected line %q in output\n%s", line, out)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package spscq
+
+import (
+	"fmt"
+	"testing"
+	"unsafe"
+)
+
+var _ PointerQueueV = (*UnboundedPointerQ)(nil)
+
+func TestUnboundedPointerQGrows(t *testing.T) {
+	q, err := NewUnboundedPointerQ(4, 0, 0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	values := make([]int64, 100)
+	for i := range values {
+		values[i] = int64(i)
+		if !q.WriteSingle(unsafe.Pointer(&values[i])) {
+			t.Fatalf("Unbounded queue rejected write %d", i)
+		}
+	}
+	if q.Segments() != 25 {
+		t.Errorf("Expected 25 segments, found %d", q.Segments())
+	}
+	for i := range values {
+		ptr := q.ReadSingle()
+		if ptr == nil {
+			t.Fatalf("Unable to read value %d", i)
+		}
+		if *(*int64)(ptr) != int64(i) {
+			t.Fatalf("Expected to read %d, found %d", i, *(*int64)(ptr))
+		}
+	}
+	if ptr := q.ReadSingle(); ptr != nil {
+		t.Errorf("Expected empty queue, read %d", *(*int64)(ptr))
+	}
+	// Only the segments which fit in the recycle queue are kept, plus
+	// the segment currently in use
+	if q.Segments() != recycledSegments+1 {
+		t.Errorf("Expected %d segments, found %d", recycledSegments+1, q.Segments())
+	}
+}
+
+func TestUnboundedPointerQRecycles(t *testing.T) {
+	q, err := NewUnboundedPointerQ(4, 0, 0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	values := make([]int64, 10)
+	for round := 0; round < 100; round++ {
+		for i := range values {
+			q.WriteSingle(unsafe.Pointer(&values[i]))
+		}
+		for range values {
+			if q.ReadSingle() == nil {
+				t.Fatalf("Unable to read in round %d", round)
+			}
+		}
+	}
+	// 10 values need 3 segments at a time, plus 1 for the writer to move
+	// on to while the reader finishes the last
+	if q.Segments() > 4 {
+		t.Errorf("Expected segments to be recycled, found %d segments", q.Segments())
+	}
+}
+
+func TestUnboundedPointerQMaxSegments(t *testing.T) {
+	q, err := NewUnboundedPointerQ(4, 0, 2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	value := int64(1)
+	ptr := unsafe.Pointer(&value)
+	for i := 0; i < 8; i++ {
+		if !q.WriteSingle(ptr) {
+			t.Fatalf("Unable to write %d", i)
+		}
+	}
+	if q.WriteSingle(ptr) {
+		t.Errorf("Expected write beyond maxSegments to fail")
+	}
+	if buffer := q.AcquireWrite(1); buffer != nil {
+		t.Errorf("Expected batch write beyond maxSegments to fail")
+	}
+	// Emptying the first segment lets the writer reuse it
+	for i := 0; i < 4; i++ {
+		q.ReadSingle()
+	}
+	if q.ReadSingle() == nil {
+		t.Fatalf("Unable to read from second segment")
+	}
+	if !q.WriteSingle(ptr) {
+		t.Errorf("Expected write to reuse recycled segment")
+	}
+	if q.Segments() != 2 {
+		t.Errorf("Expected 2 segments, found %d", q.Segments())
+	}
+}
+
+func TestUnboundedPointerQErrors(t *testing.T) {
+	if _, err := NewUnboundedPointerQ(3, 0, 0); err == nil {
+		t.Errorf("Expected error for segment size which is not a power of two")
+	}
+	if _, err := NewUnboundedPointerQ(4, 0, -1); err == nil {
+		t.Errorf("Expected error for negative maxSegments")
+	}
+}
+
+func TestUnboundedPointerQHistory(t *testing.T) {
+	for _, seed := range historySeeds {
+		segmentSize := int64(1) << uint(seed%4)
+		q, err := NewUnboundedPointerQ(segmentSize, 0, 0)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		name := fmt.Sprintf("UnboundedPointerQ(seed %d, segment size %d)", seed, segmentSize)
+		values := make([]int64, historyMsgs)
+		for i := range values {
+			values[i] = int64(i)
+		}
+		written := make(chan []int64)
+		go pointerQProducer(newSchedule(seed), q, 4*segmentSize, values, written)
+		read := pointerQConsumer(newSchedule(-seed), q, 4*segmentSize, int64(len(values)))
+		checkHistory(t, name, <-written, read)
+	}
+}
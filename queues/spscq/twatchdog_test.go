@@ -0,0 +1,109 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package spscq
+
+import (
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestWatchdogReaderStalled(t *testing.T) {
+	var stalls []Stall
+	w := NewWatchdog(time.Millisecond, 10*time.Millisecond, func(s Stall) {
+		stalls = append(stalls, s)
+	})
+	q, _ := NewPointerQ(4, 0)
+	w.Watch("reader-stalled", q)
+	start := time.Now()
+	val := 1
+	// Fill the queue and fail a write, nothing ever reads
+	for q.WriteSingle(unsafe.Pointer(&val)) {
+	}
+	w.check(start.Add(5 * time.Millisecond))
+	if len(stalls) != 0 {
+		t.Fatalf("Stall reported too early %v", stalls)
+	}
+	w.check(start.Add(20 * time.Millisecond))
+	if len(stalls) != 1 {
+		t.Fatalf("Expected 1 stall, found %v", stalls)
+	}
+	s := stalls[0]
+	if s.Name != "reader-stalled" || s.Side != ReaderStalled || s.Stats.Occupancy != 4 || s.Stats.FailedWrites != 1 {
+		t.Errorf("Unexpected stall %v", s)
+	}
+	// The stall is only reported once
+	q.WriteSingle(unsafe.Pointer(&val))
+	w.check(start.Add(30 * time.Millisecond))
+	if len(stalls) != 1 {
+		t.Fatalf("Expected stall to be reported once, found %v", stalls)
+	}
+	// Once the reader advances a new stall can be reported
+	q.ReadSingle()
+	w.check(start.Add(40 * time.Millisecond))
+	q.WriteSingle(unsafe.Pointer(&val))
+	q.WriteSingle(unsafe.Pointer(&val))
+	w.check(start.Add(60 * time.Millisecond))
+	if len(stalls) != 2 {
+		t.Fatalf("Expected a second stall, found %v", stalls)
+	}
+}
+
+func TestWatchdogWriterStalled(t *testing.T) {
+	var stalls []Stall
+	w := NewWatchdog(time.Millisecond, 10*time.Millisecond, func(s Stall) {
+		stalls = append(stalls, s)
+	})
+	q, _ := NewPointerQ(4, 0)
+	w.Watch("writer-stalled", q)
+	start := time.Now()
+	q.ReadSingle()
+	w.check(start.Add(20 * time.Millisecond))
+	if len(stalls) != 1 || stalls[0].Side != WriterStalled {
+		t.Fatalf("Expected writer stall, found %v", stalls)
+	}
+}
+
+// A queue which is not being used at all is not stalled
+func TestWatchdogIdle(t *testing.T) {
+	var stalls []Stall
+	w := NewWatchdog(time.Millisecond, 10*time.Millisecond, func(s Stall) {
+		stalls = append(stalls, s)
+	})
+	q, _ := NewPointerQ(4, 0)
+	w.Watch("idle", q)
+	w.check(time.Now().Add(time.Second))
+	if len(stalls) != 0 {
+		t.Errorf("Expected no stalls, found %v", stalls)
+	}
+}
+
+func TestWatchdogStartStop(t *testing.T) {
+	stalls := make(chan Stall, 1)
+	w := NewWatchdog(time.Millisecond, 5*time.Millisecond, func(s Stall) {
+		stalls <- s
+	})
+	q, _ := NewPointerQ(4, 0)
+	val := 1
+	for q.WriteSingle(unsafe.Pointer(&val)) {
+	}
+	w.Watch("start-stop", q)
+	q.WriteSingle(unsafe.Pointer(&val))
+	w.Start()
+	defer w.Stop()
+	select {
+	case s := <-stalls:
+		if s.Side != ReaderStalled {
+			t.Errorf("Expected reader stall, found %v", s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("No stall reported")
+	}
+}
+
+func TestWatchdogStopWithoutStart(t *testing.T) {
+	w := NewWatchdog(time.Millisecond, 5*time.Millisecond, func(Stall) {})
+	w.Stop()
+}
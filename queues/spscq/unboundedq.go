@@ -0,0 +1,257 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package spscq
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/fmstephe/flib/fsync/padded"
+	"github.com/fmstephe/flib/ftime"
+)
+
+// The number of exhausted segments kept for reuse by the writer
+const recycledSegments = 8
+
+// A segment is a PointerQ linked to the segment written after it.
+type segment struct {
+	PointerQ
+	// *segment, stored once by the writer when it moves on
+	next unsafe.Pointer
+}
+
+// An UnboundedPointerQ is a PointerQ which grows, rather than rejecting
+// writes, when it is full. It is a linked list of PointerQ segments.
+// When the writer's segment is full it links a new segment and moves on
+// to it. When the reader has emptied a segment which the writer has
+// moved on from it moves on too, handing the exhausted segment back to
+// the writer for reuse.
+//
+// In steady state, with the reader keeping up, the writer and reader
+// share a single segment which behaves exactly like a PointerQ.
+//
+// Segments are allocated by the writer, and only when there are no
+// recycled segments available. If maxSegments is greater than 0 then no
+// more than maxSegments will be allocated at once, and writes fail when
+// they are all full.
+type UnboundedPointerQ struct {
+	_prebuffer padded.CacheBuffer
+	// Readonly Fields
+	segmentSize int64
+	maxSegments int64
+	pause       int64
+	clock       ftime.Clock
+	recycle     *PointerQ
+	// Writer fields
+	_writebuffer padded.CacheBuffer
+	tail         *segment
+	// Reader fields
+	_readbuffer padded.CacheBuffer
+	head        *segment
+	// Shared fields
+	segments    padded.Int64
+	_postbuffer padded.CacheBuffer
+}
+
+func NewUnboundedPointerQ(segmentSize, pause, maxSegments int64) (*UnboundedPointerQ, error) {
+	return NewUnboundedPointerQClock(segmentSize, pause, maxSegments, ftime.RealClock{})
+}
+
+// Like NewUnboundedPointerQ, but failed reads and writes pause on clock.
+func NewUnboundedPointerQClock(segmentSize, pause, maxSegments int64, clock ftime.Clock) (*UnboundedPointerQ, error) {
+	if maxSegments < 0 {
+		return nil, errors.New(fmt.Sprintf("maxSegments (%d) must not be negative", maxSegments))
+	}
+	recycle, err := NewPointerQ(recycledSegments, 0)
+	if err != nil {
+		return nil, err
+	}
+	q := &UnboundedPointerQ{segmentSize: segmentSize, maxSegments: maxSegments, pause: pause, clock: clock, recycle: recycle}
+	first, err := q.newSegment()
+	if err != nil {
+		return nil, err
+	}
+	q.tail = first
+	q.head = first
+	q.segments.Value = 1
+	return q, nil
+}
+
+func (q *UnboundedPointerQ) newSegment() (*segment, error) {
+	// Segments never pause, the UnboundedPointerQ pauses when it fails
+	cq, err := newCommonQ(q.segmentSize, 0)
+	if err != nil {
+		return nil, err
+	}
+	s := &segment{}
+	s.commonQ = cq
+	s.ringBuffer = padded.PointerSlice(int(q.segmentSize))
+	return s, nil
+}
+
+// The number of segments currently allocated, including those waiting
+// to be reused. Safe to call from any goroutine.
+func (q *UnboundedPointerQ) Segments() int64 {
+	return atomic.LoadInt64(&q.segments.Value)
+}
+
+// Called by the writer when its segment is full. Links a recycled, or
+// new, segment after the current one. Returns false if maxSegments
+// are already allocated and none can be recycled.
+func (q *UnboundedPointerQ) grow() bool {
+	var s *segment
+	if ptr := q.recycle.ReadSingle(); ptr != nil {
+		s = (*segment)(ptr)
+	} else {
+		if q.maxSegments > 0 && atomic.LoadInt64(&q.segments.Value) >= q.maxSegments {
+			pauseOn(q.clock, q.pause)
+			return false
+		}
+		// The segment size was checked by NewUnboundedPointerQ
+		s, _ = q.newSegment()
+		atomic.AddInt64(&q.segments.Value, 1)
+	}
+	atomic.StorePointer(&q.tail.next, unsafe.Pointer(s))
+	q.tail = s
+	return true
+}
+
+// Called by the reader before every read. Moves past segments which
+// are empty and which the writer has moved on from.
+func (q *UnboundedPointerQ) advance() {
+	for {
+		head := q.head
+		if head.read.Value < head.writeCache.Value {
+			return
+		}
+		next := atomic.LoadPointer(&head.next)
+		if next == nil {
+			return
+		}
+		// The writer released its final writes before storing next
+		head.refreshWriteCache()
+		if head.read.Value < head.writeCache.Value {
+			return
+		}
+		q.head = (*segment)(next)
+		q.retire(head)
+	}
+}
+
+// Hands an exhausted segment back to the writer. If enough segments are
+// already waiting to be reused it is left for the garbage collector.
+func (q *UnboundedPointerQ) retire(s *segment) {
+	s.next = nil
+	if !q.recycle.WriteSingle(unsafe.Pointer(s)) {
+		atomic.AddInt64(&q.segments.Value, -1)
+	}
+}
+
+func (q *UnboundedPointerQ) readFailed() {
+	pauseOn(q.clock, q.pause)
+}
+
+func (q *UnboundedPointerQ) AcquireRead(bufferSize int64) []unsafe.Pointer {
+	q.advance()
+	buffer := q.head.AcquireRead(bufferSize)
+	if buffer == nil {
+		q.readFailed()
+	}
+	return buffer
+}
+
+func (q *UnboundedPointerQ) AcquireReadV(bufferSize int64) ([]unsafe.Pointer, []unsafe.Pointer) {
+	q.advance()
+	buffer, wrapped := q.head.AcquireReadV(bufferSize)
+	if buffer == nil {
+		q.readFailed()
+	}
+	return buffer, wrapped
+}
+
+func (q *UnboundedPointerQ) ReleaseRead() {
+	q.head.ReleaseRead()
+}
+
+func (q *UnboundedPointerQ) ReleaseReadLazy() {
+	q.head.ReleaseReadLazy()
+}
+
+func (q *UnboundedPointerQ) AcquireWrite(bufferSize int64) []unsafe.Pointer {
+	if buffer := q.tail.AcquireWrite(bufferSize); buffer != nil {
+		return buffer
+	}
+	if !q.grow() {
+		return nil
+	}
+	return q.tail.AcquireWrite(bufferSize)
+}
+
+func (q *UnboundedPointerQ) AcquireWriteV(bufferSize int64) ([]unsafe.Pointer, []unsafe.Pointer) {
+	if buffer, wrapped := q.tail.AcquireWriteV(bufferSize); buffer != nil {
+		return buffer, wrapped
+	}
+	if !q.grow() {
+		return nil, nil
+	}
+	return q.tail.AcquireWriteV(bufferSize)
+}
+
+func (q *UnboundedPointerQ) ReleaseWrite() {
+	q.tail.ReleaseWrite()
+}
+
+func (q *UnboundedPointerQ) ReleaseWriteLazy() {
+	q.tail.ReleaseWriteLazy()
+}
+
+func (q *UnboundedPointerQ) WriteSingle(val unsafe.Pointer) bool {
+	if q.tail.WriteSingle(val) {
+		return true
+	}
+	return q.grow() && q.tail.WriteSingle(val)
+}
+
+func (q *UnboundedPointerQ) WriteSingleBlocking(val unsafe.Pointer) {
+	b := q.WriteSingle(val)
+	for !b {
+		b = q.WriteSingle(val)
+	}
+}
+
+func (q *UnboundedPointerQ) WriteSingleLazy(val unsafe.Pointer) bool {
+	if q.tail.WriteSingleLazy(val) {
+		return true
+	}
+	return q.grow() && q.tail.WriteSingleLazy(val)
+}
+
+func (q *UnboundedPointerQ) ReadSingle() unsafe.Pointer {
+	q.advance()
+	val := q.head.ReadSingle()
+	if val == nil {
+		q.readFailed()
+	}
+	return val
+}
+
+func (q *UnboundedPointerQ) ReadSingleBlocking() unsafe.Pointer {
+	val := q.ReadSingle()
+	for val == nil {
+		val = q.ReadSingle()
+	}
+	return val
+}
+
+func (q *UnboundedPointerQ) ReadSingleLazy() unsafe.Pointer {
+	q.advance()
+	val := q.head.ReadSingleLazy()
+	if val == nil {
+		q.readFailed()
+	}
+	return val
+}
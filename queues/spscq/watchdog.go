@@ -0,0 +1,186 @@
+// Copyright 2016 Francis Stephens. All rights reserved.
+// Use of this source code is governed by a BSD
+// license which can be found in LICENSE.txt
+
+package spscq
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fmstephe/flib/ftime"
+)
+
+type StallSide int
+
+const (
+	ReaderStalled StallSide = iota
+	WriterStalled
+)
+
+func (s StallSide) String() string {
+	if s == ReaderStalled {
+		return "reader"
+	}
+	return "writer"
+}
+
+// Describes a queue where one side has stopped advancing while the
+// other side keeps failing to read or write.
+type Stall struct {
+	Name    string
+	Side    StallSide
+	Stalled time.Duration
+	Stats   Stats
+}
+
+func (s Stall) String() string {
+	return fmt.Sprintf("Queue (%s) %s has not advanced for %s, occupancy %d, failedWrites %d, failedReads %d", s.Name, s.Side, s.Stalled, s.Stats.Occupancy, s.Stats.FailedWrites, s.Stats.FailedReads)
+}
+
+// A Watchdog periodically samples the read and write positions of the
+// queues it watches. If the reader has not advanced for stallAfter,
+// while the writer has failed writes in that time, then the reader is
+// reported as stalled. Likewise for a writer which has not advanced
+// while the reader has failed reads. Note that an idle writer will be
+// reported if the reader keeps trying to read from it.
+//
+// Each stall is reported once, until the stalled side advances again.
+type Watchdog struct {
+	interval   time.Duration
+	stallAfter time.Duration
+	onStall    func(Stall)
+	clock      ftime.Clock
+	mu         sync.Mutex
+	watched    map[string]*watchState
+	stop       chan bool
+	done       chan bool
+}
+
+type watchState struct {
+	q     StatsReporter
+	last  Stats
+	read  sideState
+	write sideState
+}
+
+type sideState struct {
+	moved    time.Time
+	failed   int64 // failures on the other side when this side last moved
+	reported bool
+}
+
+// If onStall is nil stalls are written to the standard logger.
+func NewWatchdog(interval, stallAfter time.Duration, onStall func(Stall)) *Watchdog {
+	return NewWatchdogClock(interval, stallAfter, onStall, ftime.RealClock{})
+}
+
+// Like NewWatchdog, but stalls are measured using clock's Now().
+func NewWatchdogClock(interval, stallAfter time.Duration, onStall func(Stall), clock ftime.Clock) *Watchdog {
+	if onStall == nil {
+		onStall = func(s Stall) {
+			log.Print(s.String())
+		}
+	}
+	return &Watchdog{
+		interval:   interval,
+		stallAfter: stallAfter,
+		onStall:    onStall,
+		clock:      clock,
+		watched:    make(map[string]*watchState),
+	}
+}
+
+func (w *Watchdog) now() time.Time {
+	return w.clock.Now()
+}
+
+func (w *Watchdog) Watch(name string, q StatsReporter) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := w.now()
+	stats := q.Stats()
+	w.watched[name] = &watchState{
+		q:     q,
+		last:  stats,
+		read:  sideState{moved: now, failed: stats.FailedWrites},
+		write: sideState{moved: now, failed: stats.FailedReads},
+	}
+}
+
+func (w *Watchdog) Unwatch(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.watched, name)
+}
+
+// Starts a goroutine which checks the watched queues every interval.
+func (w *Watchdog) Start() {
+	w.stop = make(chan bool)
+	w.done = make(chan bool)
+	go func() {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		defer close(w.done)
+		for {
+			select {
+			case <-ticker.C:
+				w.check(w.now())
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stops the goroutine started by Start and waits for it to exit. Does
+// nothing if Start was not called.
+func (w *Watchdog) Stop() {
+	if w.stop == nil {
+		return
+	}
+	close(w.stop)
+	<-w.done
+}
+
+// Checks the watched queues immediately, as the goroutine started by
+// Start does every interval.
+func (w *Watchdog) Check() {
+	w.check(w.now())
+}
+
+func (w *Watchdog) check(now time.Time) {
+	var stalls []Stall
+	w.mu.Lock()
+	for name, ws := range w.watched {
+		stats := ws.q.Stats()
+		if stats.Read != ws.last.Read {
+			ws.read = sideState{moved: now, failed: stats.FailedWrites}
+		}
+		if stats.Written != ws.last.Written {
+			ws.write = sideState{moved: now, failed: stats.FailedReads}
+		}
+		ws.last = stats
+		if w.stalled(&ws.read, now, stats.FailedWrites) {
+			stalls = append(stalls, Stall{Name: name, Side: ReaderStalled, Stalled: now.Sub(ws.read.moved), Stats: stats})
+		}
+		if w.stalled(&ws.write, now, stats.FailedReads) {
+			stalls = append(stalls, Stall{Name: name, Side: WriterStalled, Stalled: now.Sub(ws.write.moved), Stats: stats})
+		}
+	}
+	w.mu.Unlock()
+	// Called without holding the lock, so onStall may call Unwatch
+	for _, s := range stalls {
+		w.onStall(s)
+	}
+}
+
+func (w *Watchdog) stalled(side *sideState, now time.Time, otherFailed int64) bool {
+	if side.reported || now.Sub(side.moved) < w.stallAfter || otherFailed == side.failed {
+		return false
+	}
+	side.reported = true
+	return true
+}